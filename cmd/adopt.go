@@ -0,0 +1,125 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func installedPackages() (map[string]string, error) {
+	// ask dpkg for every installed package and its version in one call
+	out, err := exec.Command("dpkg-query", "-W", "-f=${Package}\t${Status}\t${Version}\n").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed packages: %v", err)
+	}
+
+	installed := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || fields[1] != "install ok installed" {
+			continue
+		}
+		installed[fields[0]] = fields[2]
+	}
+	return installed, nil
+}
+
+func adoptPackage(entry catalogEntry, version string) error {
+	// record a package installed outside ezdeb as if ezdeb installed it
+	// there is no downloaded .deb so the asset, checksum and signer stay empty
+	record := packageRecord(entry.catalog, entry.pkg)
+	record.Version = version
+	record.Adopted = true
+	return storePackageState(record)
+}
+
+// adoptCmd represents the adopt command
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "Manage packages that were installed without ezdeb",
+	Long: `Manage packages that were installed without ezdeb
+Matches packages installed with dpkg against the catalog and records their installed version,
+so update and hold handle them. Nothing is downloaded or reinstalled.
+Use catalog/package to adopt the entry of a particular catalog.
+Usage: ezdeb adopt [pkg...] [--all]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// init logging
+		logger, err := InitLogger()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		all, _ := cmd.Flags().GetBool("all")
+		if len(args) < 1 && !all {
+			fmt.Println(Red, "Please provide a package name or --all", Reset)
+			return
+		}
+
+		installed, err := installedPackages()
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			return
+		}
+		state, err := readState()
+		if err != nil {
+			fmt.Println(Red, "Failed to read installed packages:", err, Reset)
+			return
+		}
+
+		var entries []catalogEntry
+		if all {
+			// every catalog package dpkg knows about and ezdeb doesn't
+			for _, entry := range catalogPackages() {
+				if _, found := installed[entry.pkg.debName()]; found && state.Packages[entry.pkg.debName()] == nil {
+					entries = append(entries, entry)
+				}
+			}
+			if len(entries) == 0 {
+				fmt.Println(Green, "No installed catalog packages to adopt", Reset)
+				return
+			}
+		} else {
+			for _, pkg := range args {
+				entry, found := lookupCatalogEntry(pkg)
+				if !found {
+					fmt.Println(Red, "Package", pkg, "not found in the catalog", Reset)
+					continue
+				}
+				if _, found := installed[entry.pkg.debName()]; !found {
+					fmt.Println(Red, "Package", pkg, "is not installed", Reset)
+					continue
+				}
+				if state.Packages[entry.pkg.debName()] != nil {
+					fmt.Println(Yellow, "Package", pkg, "is already managed by ezdeb", Reset)
+					continue
+				}
+				entries = append(entries, entry)
+			}
+		}
+
+		for _, entry := range entries {
+			name := entry.pkg.debName()
+			version := installed[name]
+			if err := adoptPackage(entry, version); err != nil {
+				fmt.Println(Red, "Failed to adopt package", name+":", err, Reset)
+				continue
+			}
+			logger.Infof("adopt: %v %v", entry.qualifiedName(), version)
+			fmt.Println(Green, "Adopted", name, version, "from catalog", entry.catalog, Reset)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adoptCmd)
+	withLock(adoptCmd)
+
+	adoptCmd.Flags().Bool("all", false, "Adopt every installed package found in the catalog")
+}
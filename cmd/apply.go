@@ -0,0 +1,388 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// applyStep is one change apply makes to converge the machine to a manifest
+type applyStep struct {
+	action string
+	name   string
+	detail string
+	run    func(ctx context.Context) error
+}
+
+func (s applyStep) String() string {
+	if s.detail == "" {
+		return s.action + " " + s.name
+	}
+	return s.action + " " + s.name + " (" + s.detail + ")"
+}
+
+func planCatalogs(m *manifest, base string) ([]applyStep, error) {
+	// add the catalogs of the manifest that are missing and update the ones that changed
+	// local catalog paths are relative to the directory of the manifest
+	configured, err := readCatalogConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []applyStep
+	for _, mc := range m.Catalogs {
+		c := catalogConfig{
+			Name:     mc.Name,
+			URL:      mc.URL,
+			Mirrors:  mc.Mirrors,
+			Priority: mc.Priority,
+			Key:      parseMinisignPublicKey(mc.Key),
+		}
+		if c.Priority == 0 {
+			c.Priority = defaultCatalogPriority
+		}
+		if !isRemoteLocation(c.URL) {
+			location := strings.TrimPrefix(c.URL, "file://")
+			if !filepath.IsAbs(location) {
+				location = filepath.Join(base, location)
+			}
+			c.URL = location
+		}
+
+		action := "add catalog"
+		for _, old := range configured {
+			if old.Name == c.Name {
+				action = "update catalog"
+				if reflect.DeepEqual(old, c) {
+					action = ""
+				}
+			}
+		}
+		if action == "" {
+			continue
+		}
+
+		steps = append(steps, applyStep{action: action, name: c.Name, detail: c.URL, run: func(ctx context.Context) error {
+			catalogs, err := readCatalogConfigs()
+			if err != nil {
+				return err
+			}
+			kept := catalogs[:0]
+			for _, old := range catalogs {
+				if old.Name != c.Name {
+					kept = append(kept, old)
+				}
+			}
+			if err := writeCatalogConfigs(append(kept, c)); err != nil {
+				return err
+			}
+			result, err := syncCatalog(ctx, c)
+			if err != nil {
+				return fmt.Errorf("failed to sync catalog %s: %v", c.Name, err)
+			}
+			printCatalogSync(c.Name, result)
+			return nil
+		}})
+	}
+	return steps, nil
+}
+
+func installStep(action string, p manifestPackage, lf *lockfile) applyStep {
+	// install the latest release of a package, with a lockfile the locked artifact is installed instead
+	detail := "latest"
+	if lf != nil {
+		detail = p.Pin + ", locked"
+	}
+	return applyStep{action: action, name: p.qualifiedName(), detail: detail, run: func(ctx context.Context) error {
+		var entry catalogEntry
+		var rel Release
+		var deb *fetchedDeb
+		var err error
+		if lf != nil {
+			var locked lockedPackage
+			if entry, locked, err = lockedEntry(lf, p.qualifiedName()); err == nil {
+				rel, deb, err = fetchLocked(ctx, entry, locked)
+			}
+		} else {
+			var found bool
+			if entry, found = lookupCatalogEntry(p.qualifiedName()); !found {
+				return fmt.Errorf("package %s not found in the catalog", p.qualifiedName())
+			}
+			rel, deb, err = fetchEntry(ctx, entry)
+		}
+		if err != nil {
+			return err
+		}
+		return installFetched(p, entry, rel, deb)
+	}}
+}
+
+func pinnedStep(action string, p manifestPackage) applyStep {
+	// sources only offer their latest release, so a pin is met by a latest release at or above it
+	// the release is resolved when the step runs, after apply added the catalogs it may come from
+	return applyStep{action: action, name: p.qualifiedName(), detail: "pinned " + p.Pin + " or newer", run: func(ctx context.Context) error {
+		entry, found := lookupCatalogEntry(p.qualifiedName())
+		if !found {
+			return fmt.Errorf("package %s not found in the catalog", p.qualifiedName())
+		}
+		rel, deb, err := fetchEntry(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if cmp, err := compareDebVersions(deb.info.Version, p.Pin); err != nil {
+			os.Remove(deb.location)
+			return fmt.Errorf("failed to compare versions: %v", err)
+		} else if cmp < 0 {
+			os.Remove(deb.location)
+			return fmt.Errorf("pinned to %s but the latest release is %s", p.Pin, deb.info.Version)
+		}
+		return installFetched(p, entry, rel, deb)
+	}}
+}
+
+func manifestDebName(p manifestPackage, state *ezdebState) string {
+	// dpkg and the state know the package by the name its entry installs
+	// entries of catalogs apply hasn't synced yet are found by the entry the state recorded
+	if entry, found := lookupCatalogEntry(p.qualifiedName()); found {
+		return entry.pkg.debName()
+	}
+	for _, name := range state.names(false) {
+		record := state.Packages[name]
+		if record.qualifiedEntry() == p.qualifiedName() || (p.Catalog == "" && record.entryName() == p.Name) {
+			return name
+		}
+	}
+	return p.Name
+}
+
+func installFetched(p manifestPackage, entry catalogEntry, rel Release, deb *fetchedDeb) error {
+	if err := installPackage(deb.location); err != nil {
+		return err
+	}
+	return storePackageDetails(entry.catalog, entry.pkg, rel, deb)
+}
+
+func planPackages(m *manifest, prune bool, lf *lockfile) ([]applyStep, error) {
+	// compare the packages of the manifest with dpkg and the ezdeb state
+	// with a lockfile every package is pinned to its locked version
+	if lf != nil {
+		for i, p := range m.Packages {
+			locked, found := lf.find(p.qualifiedName())
+			if !found {
+				return nil, fmt.Errorf("package %s is not in the lockfile", p.qualifiedName())
+			}
+			if p.Pin != "" {
+				if cmp, err := compareDebVersions(locked.Version, p.Pin); err != nil || cmp < 0 {
+					return nil, fmt.Errorf("package %s is pinned to %s but locked at %s", p.Name, p.Pin, locked.Version)
+				}
+			}
+			m.Packages[i].Pin = locked.Version
+		}
+	}
+
+	installed, err := installedPackages()
+	if err != nil {
+		return nil, err
+	}
+	state, err := readState()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []applyStep
+	var errs catalogErrors
+	listed := make(map[string]bool)
+	for _, p := range m.Packages {
+		p := p
+		name := manifestDebName(p, state)
+		listed[name] = true
+		record := state.Packages[name]
+		version, isInstalled := installed[name]
+		held := record != nil && record.Hold != nil
+
+		// a pin is met by the installed version or a newer one, held packages are never updated
+		action := ""
+		switch {
+		case !isInstalled:
+			action = "install"
+		case p.Pin != "" && !held:
+			if cmp, err := compareDebVersions(version, p.Pin); err != nil {
+				errs = append(errs, fmt.Errorf("package %s: failed to compare versions: %v", p.Name, err))
+			} else if cmp < 0 {
+				action = "update"
+			}
+		}
+
+		switch {
+		case action != "" && p.Pin != "" && lf == nil:
+			steps = append(steps, pinnedStep(action, p))
+		case action != "":
+			steps = append(steps, installStep(action, p, lf))
+		case record == nil:
+			// installed without ezdeb, take it over as is
+			steps = append(steps, applyStep{action: "adopt", name: p.qualifiedName(), detail: version, run: func(ctx context.Context) error {
+				entry, found := lookupCatalogEntry(p.qualifiedName())
+				if !found {
+					return fmt.Errorf("package %s not found in the catalog", p.qualifiedName())
+				}
+				return adoptPackage(entry, version)
+			}})
+		}
+
+		if p.Hold && !held {
+			steps = append(steps, applyStep{action: "hold", name: p.Name, run: func(ctx context.Context) error {
+				// the package may only be known by its entry name once its catalog is synced
+				return setHold([]string{manifestDebName(p, state)}, true)
+			}})
+		}
+		if !p.Hold && held {
+			steps = append(steps, applyStep{action: "unhold", name: p.Name, run: func(ctx context.Context) error {
+				return setHold([]string{manifestDebName(p, state)}, false)
+			}})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if prune {
+		for _, name := range state.names(false) {
+			name := name
+			if listed[name] {
+				continue
+			}
+			steps = append(steps, applyStep{action: "uninstall", name: name, run: func(ctx context.Context) error {
+				if _, found := installed[name]; found {
+					if err := uninstallPkg(name); err != nil {
+						return err
+					}
+				}
+				return deletePkgConfig(name)
+			}})
+		}
+	}
+
+	return steps, nil
+}
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Converge the machine to a manifest",
+	Long: `Converge the machine to a manifest written by ezdeb export
+Adds the listed catalogs, installs or adopts the listed packages, updates pinned packages
+older than their pin unless they are held and holds or unholds them. Running it again changes nothing.
+A pin the latest release can't meet fails that package without installing it.
+--prune uninstalls the packages ezdeb manages that the manifest doesn't list.
+--dry-run only shows the plan.
+--locked installs exactly the artifacts recorded by ezdeb lock.
+Usage: ezdeb apply <ezdeb.yaml|-> [--prune] [--dry-run] [--locked [--lockfile ezdeb.lock]]`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// init logging
+		logger, err := InitLogger()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		prune, _ := cmd.Flags().GetBool("prune")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		m, err := readManifest(args[0])
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+
+		base, _ := os.Getwd()
+		if args[0] != "-" {
+			base = filepath.Dir(args[0])
+		}
+		if base, err = filepath.Abs(base); err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+
+		catalogSteps, err := planCatalogs(m, base)
+		if err != nil {
+			fmt.Println(Red, "Failed to plan catalogs:", err, Reset)
+			os.Exit(1)
+		}
+		// --locked installs the artifacts recorded by ezdeb lock, by default the lockfile next to the manifest
+		var lf *lockfile
+		if locked, _ := cmd.Flags().GetBool("locked"); locked {
+			lockfilePath := filepath.Join(base, defaultLockfile)
+			if cmd.Flags().Changed("lockfile") {
+				lockfilePath = cmd.Flag("lockfile").Value.String()
+			}
+			if lf, err = readLockfile(lockfilePath); err != nil {
+				fmt.Println(Red, err, Reset)
+				os.Exit(1)
+			}
+		}
+
+		packageSteps, err := planPackages(m, prune, lf)
+		if err != nil {
+			fmt.Println(Red, "Failed to plan packages:", err, Reset)
+			os.Exit(1)
+		}
+		steps := append(catalogSteps, packageSteps...)
+
+		if len(steps) == 0 {
+			fmt.Println(Green, "Nothing to do, the system matches", args[0], Reset)
+			return
+		}
+
+		fmt.Println(Cyan, "Plan:", Reset)
+		for _, step := range steps {
+			fmt.Println("   ", step)
+		}
+		if dryRun {
+			return
+		}
+
+		// catalogs are added and synced first, the package steps resolve their entries when they run
+		ctx := context.Background()
+		failed := 0
+		for _, step := range steps {
+			fmt.Println()
+			fmt.Println(Cyan, step, Reset)
+			if err := step.run(ctx); err != nil {
+				failed++
+				fmt.Println(Red, "Failed to", step.action, step.name+":", err, Reset)
+				continue
+			}
+			logger.Infof("apply: %v", step)
+		}
+
+		if failed > 0 {
+			fmt.Println()
+			fmt.Println(Red, failed, "of", len(steps), "steps failed", Reset)
+			os.Exit(1)
+		}
+		fmt.Println()
+		fmt.Println(Green, "Applied", args[0], Reset)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	withLock(applyCmd)
+
+	applyCmd.Flags().Bool("prune", false, "Uninstall managed packages the manifest doesn't list")
+	applyCmd.Flags().Bool("dry-run", false, "Only show what would change")
+	applyCmd.Flags().BoolVar(&requireChecksum, "require-checksum", false, "Refuse packages without a known SHA256 checksum")
+	applyCmd.Flags().BoolVar(&allowUnsignedRepo, "allow-unsigned-repo", false, "Accept apt repositories without a gpg key in the catalog")
+	applyCmd.Flags().Bool("locked", false, "Install exactly the artifacts recorded in the lockfile")
+	applyCmd.Flags().String("lockfile", defaultLockfile, "Lockfile used by --locked, by default the one next to the manifest")
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// archOverride is set by the --arch flag to fetch packages for another architecture
+var archOverride string
+
+// hostArch caches the architecture reported by dpkg
+var hostArch string
+
+// archAliases maps Debian architecture names to the names release assets commonly use
+var archAliases = map[string][]string{
+	"amd64":   {"amd64", "x86_64", "x86-64", "x64"},
+	"arm64":   {"arm64", "aarch64"},
+	"armhf":   {"armhf", "armv7", "armv7l", "armv7hl"},
+	"armel":   {"armel", "armv6", "armv6l"},
+	"i386":    {"i386", "i686", "x86"},
+	"ppc64el": {"ppc64el", "ppc64le"},
+	"s390x":   {"s390x"},
+	"riscv64": {"riscv64"},
+}
+
+func initArch() {
+	// --arch accepts the Debian names and their aliases, e.g. aarch64 for arm64
+	if archOverride == "" {
+		return
+	}
+	arch, err := canonicalArch(archOverride)
+	if err != nil {
+		fmt.Println(Red, err, Reset)
+		os.Exit(1)
+	}
+	archOverride = arch
+}
+
+func canonicalArch(name string) (string, error) {
+	// return the Debian architecture a name or one of its aliases stands for
+	lower := strings.ToLower(strings.TrimSpace(name))
+	var known []string
+	for arch, names := range archAliases {
+		for _, n := range names {
+			if n == lower {
+				return arch, nil
+			}
+		}
+		known = append(known, arch)
+	}
+	sort.Strings(known)
+	return "", fmt.Errorf("unknown architecture %q, use one of %s", name, strings.Join(known, ", "))
+}
+
+func debArch() string {
+	// return the Debian architecture packages are fetched for
+	// the --arch flag wins, then dpkg, then the architecture ezdeb was built for
+	if archOverride != "" {
+		return archOverride
+	}
+	if hostArch != "" {
+		return hostArch
+	}
+
+	if out, err := exec.Command("dpkg", "--print-architecture").Output(); err == nil {
+		hostArch = strings.TrimSpace(string(out))
+	}
+	if hostArch == "" {
+		switch runtime.GOARCH {
+		case "arm":
+			hostArch = "armhf"
+		case "386":
+			hostArch = "i386"
+		case "ppc64le":
+			hostArch = "ppc64el"
+		default:
+			hostArch = runtime.GOARCH
+		}
+	}
+
+	return hostArch
+}
+
+func isArchBoundary(name string, i int) bool {
+	// check if position i of name is before the start, past the end or a separator
+	if i < 0 || i >= len(name) {
+		return true
+	}
+	c := name[i]
+	return !isDigit(c) && !isAlpha(c)
+}
+
+func assetArch(name string) string {
+	// return the Debian architecture named in an asset file name, empty if it names none
+	// longer aliases are tried first so x86_64 is not read as x86
+	type alias struct {
+		arch string
+		name string
+	}
+	var aliases []alias
+	for arch, names := range archAliases {
+		for _, n := range names {
+			aliases = append(aliases, alias{arch, n})
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		if len(aliases[i].name) != len(aliases[j].name) {
+			return len(aliases[i].name) > len(aliases[j].name)
+		}
+		return aliases[i].name < aliases[j].name
+	})
+
+	lower := strings.ToLower(name)
+	for _, a := range aliases {
+		for start := 0; start < len(lower); {
+			i := strings.Index(lower[start:], a.name)
+			if i < 0 {
+				break
+			}
+			i += start
+			if isArchBoundary(lower, i-1) && isArchBoundary(lower, i+len(a.name)) {
+				return a.arch
+			}
+			start = i + 1
+		}
+	}
+
+	return ""
+}
+
+func archMatches(arch string) bool {
+	// check if a control file Architecture can be installed for the target architecture
+	return arch == "all" || arch == debArch()
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import "testing"
+
+func TestAssetArch(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		// at the start and the end of the name
+		{"amd64.deb", "amd64"},
+		{"tool_1.0_arm64", "arm64"},
+		{"aarch64-tool.deb", "arm64"},
+		// between separators
+		{"tool_1.0_amd64.deb", "amd64"},
+		{"tool-1.0-x86_64.deb", "amd64"},
+		{"tool_1.0_i686.deb", "i386"},
+		{"tool-linux-armv7.deb", "armhf"},
+		{"TOOL_1.0_AMD64.deb", "amd64"},
+		// embedded in a longer word
+		{"xamd64.deb", ""},
+		{"tool_1.0_amd64x.deb", ""},
+		{"tool_1.0_myx64build.deb", ""},
+		{"tool_1.0_all.deb", ""},
+		// an embedded alias doesn't hide a later real one
+		{"xamd64_arm64.deb", "arm64"},
+	}
+
+	for _, tt := range tests {
+		if got := assetArch(tt.name); got != tt.want {
+			t.Errorf("assetArch(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalArch(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"arm64", "arm64", false},
+		{"aarch64", "arm64", false},
+		{"x86_64", "amd64", false},
+		{"AMD64", "amd64", false},
+		{"armv7l", "armhf", false},
+		{"ppc64le", "ppc64el", false},
+		{"all", "", true},
+		{"sparc", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := canonicalArch(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("canonicalArch(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
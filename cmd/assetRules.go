@@ -0,0 +1,136 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// assetRules decide which .deb of a release gets installed
+// they come from the optional catalog fields asset_pattern, asset_exclude and asset_arch
+type assetRules struct {
+	// pattern must match the asset name
+	pattern *regexp.Regexp
+	// exclude must not match the asset name
+	exclude *regexp.Regexp
+	// arch replaces pattern for a specific Debian architecture
+	arch map[string]*regexp.Regexp
+}
+
+// assetRejection records why a release asset was not selected
+type assetRejection struct {
+	name   string
+	reason string
+}
+
+func newAssetRules(pkg *Package) (assetRules, error) {
+	// compile the asset selection rules of a catalog entry
+	var rules assetRules
+	var err error
+
+	name := pkg.Name
+
+	if pattern := pkg.AssetPattern; pattern != "" {
+		if rules.pattern, err = regexp.Compile(pattern); err != nil {
+			return rules, fmt.Errorf("catalog entry %q has an invalid asset_pattern: %v", name, err)
+		}
+	}
+
+	if exclude := pkg.AssetExclude; exclude != "" {
+		if rules.exclude, err = regexp.Compile(exclude); err != nil {
+			return rules, fmt.Errorf("catalog entry %q has an invalid asset_exclude: %v", name, err)
+		}
+	}
+
+	if len(pkg.AssetArch) > 0 {
+		rules.arch = make(map[string]*regexp.Regexp)
+		for arch, pattern := range pkg.AssetArch {
+			re, err := regexp.Compile(pattern)
+			if err != nil || pattern == "" {
+				return rules, fmt.Errorf("catalog entry %q has an invalid asset_arch pattern for %s", name, arch)
+			}
+			rules.arch[arch] = re
+		}
+	}
+
+	return rules, nil
+}
+
+func selectDebAsset(assets []Asset, rules assetRules) (Asset, error) {
+	// pick the .deb to install from the files of a release
+	// every rejected asset is reported with its reason when running with --verbose
+	var rejected []assetRejection
+	var candidates []Asset
+
+	arch := debArch()
+
+	// the arch specific pattern replaces the general one
+	pattern := rules.pattern
+	if re, ok := rules.arch[arch]; ok {
+		pattern = re
+	}
+
+	for _, a := range assets {
+		switch {
+		case filepath.Ext(a.Name) != ".deb":
+			rejected = append(rejected, assetRejection{a.Name, "not a .deb file"})
+		case rules.exclude != nil && rules.exclude.MatchString(a.Name):
+			rejected = append(rejected, assetRejection{a.Name, fmt.Sprintf("matches asset_exclude %q", rules.exclude)})
+		case pattern != nil && !pattern.MatchString(a.Name):
+			rejected = append(rejected, assetRejection{a.Name, fmt.Sprintf("does not match asset pattern %q", pattern)})
+		case assetArch(a.Name) != "" && assetArch(a.Name) != arch:
+			rejected = append(rejected, assetRejection{a.Name, "built for " + assetArch(a.Name) + ", not " + arch})
+		default:
+			candidates = append(candidates, a)
+		}
+	}
+
+	// prefer .deb files with our architecture in their name over ones that name none
+	var archCandidates []Asset
+	for _, a := range candidates {
+		if assetArch(a.Name) == arch {
+			archCandidates = append(archCandidates, a)
+		}
+	}
+	if len(archCandidates) > 0 {
+		for _, a := range candidates {
+			if assetArch(a.Name) != arch {
+				rejected = append(rejected, assetRejection{a.Name, "another asset names architecture " + arch})
+			}
+		}
+		candidates = archCandidates
+	}
+
+	// several flavours can be left, e.g. -musl or -dbgsym builds next to the plain one
+	// pick the shortest name and break ties alphabetically so the choice never depends on upload order
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if len(candidates[i].Name) != len(candidates[j].Name) {
+			return len(candidates[i].Name) < len(candidates[j].Name)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	for i := 1; i < len(candidates); i++ {
+		rejected = append(rejected, assetRejection{candidates[i].Name, "preferred " + candidates[0].Name})
+	}
+
+	if verbose {
+		for _, r := range rejected {
+			fmt.Println(Yellow, "Rejected asset", r.name+":", r.reason, Reset)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return Asset{}, fmt.Errorf("no .deb file asset found in release")
+	}
+
+	if verbose {
+		fmt.Println(Cyan, "Selected asset", candidates[0].Name, Reset)
+	}
+
+	return candidates[0], nil
+}
@@ -0,0 +1,263 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// catalogCmd represents the catalog command
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Manage the package catalogs",
+	Long: `Manage the package catalogs ezdeb installs from
+The upstream packageList is the catalog "main" with priority 100.
+Catalogs with a lower priority are searched first, use catalog/package to pick one explicitly.
+Usage: ezdeb catalog add|remove|list|history|diff`,
+}
+
+// catalogAddCmd represents the catalog add command
+var catalogAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a catalog",
+	Long: `Add a catalog from an http(s) url, a local path or a directory and sync it
+Usage: ezdeb catalog add <name> <url|path> [--priority n] [--key minisign_public_key]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			fmt.Println(Red, "Please provide a catalog name and location", Reset)
+			return
+		}
+
+		name, location := args[0], args[1]
+		if !catalogNamePattern.MatchString(name) || name == mainCatalog || name == overlayCatalog {
+			fmt.Println(Red, "Invalid catalog name", name, Reset)
+			return
+		}
+
+		catalogs, err := readCatalogConfigs()
+		if err != nil {
+			fmt.Println(Red, "Failed to read catalogs:", err, Reset)
+			return
+		}
+		for _, c := range catalogs {
+			if c.Name == name {
+				fmt.Println(Red, "Catalog", name, "already exists", Reset)
+				return
+			}
+		}
+
+		// store local paths absolute so sync works from any directory
+		if !isRemoteLocation(location) {
+			if location, err = filepath.Abs(strings.TrimPrefix(location, "file://")); err != nil {
+				fmt.Println(Red, "Invalid catalog path:", err, Reset)
+				return
+			}
+			if _, err := localCatalogPath(location, name); err != nil {
+				fmt.Println(Red, "Catalog file not found:", err, Reset)
+				return
+			}
+		}
+
+		priority, _ := cmd.Flags().GetInt("priority")
+		c := catalogConfig{
+			Name:     name,
+			URL:      location,
+			Priority: priority,
+			Key:      parseMinisignPublicKey(cmd.Flag("key").Value.String()),
+		}
+
+		if err := writeCatalogConfigs(append(catalogs, c)); err != nil {
+			fmt.Println(Red, "Failed to store catalog:", err, Reset)
+			return
+		}
+		fmt.Println(Green, "Added catalog", name, Reset)
+
+		result, err := syncCatalog(context.Background(), c)
+		if err != nil {
+			fmt.Println(Yellow, "Failed to sync catalog", name+":", err, Reset)
+			fmt.Println(Yellow, "Run 'ezdeb sync' to try again", Reset)
+			return
+		}
+		printCatalogSync(name, result)
+	},
+}
+
+// catalogRemoveCmd represents the catalog remove command
+var catalogRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a catalog",
+	Long: `Remove a catalog and its synced package list
+Usage: ezdeb catalog remove <name>`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println(Red, "Please provide a catalog name", Reset)
+			return
+		}
+
+		name := args[0]
+		if name == mainCatalog {
+			fmt.Println(Red, "The main catalog can't be removed", Reset)
+			return
+		}
+
+		catalogs, err := readCatalogConfigs()
+		if err != nil {
+			fmt.Println(Red, "Failed to read catalogs:", err, Reset)
+			return
+		}
+
+		kept := catalogs[:0]
+		for _, c := range catalogs {
+			if c.Name != name {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == len(catalogs) {
+			fmt.Println(Red, "Catalog", name, "does not exist", Reset)
+			return
+		}
+
+		if err := writeCatalogConfigs(kept); err != nil {
+			fmt.Println(Red, "Failed to store catalogs:", err, Reset)
+			return
+		}
+
+		// remove the synced package list, its signature, cache and history
+		if filePath, err := catalogPath(name); err == nil {
+			os.Remove(filePath)
+			os.Remove(filePath + ".minisig")
+			os.Remove(filePath + ".cache")
+		}
+		if dir, err := historyDir(name); err == nil {
+			os.RemoveAll(dir)
+		}
+
+		fmt.Println(Green, "Removed catalog", name, Reset)
+	},
+}
+
+// catalogListCmd represents the catalog list command
+var catalogListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the catalogs",
+	Long: `List the catalogs in the order they are searched
+Usage: ezdeb catalog list`,
+	Run: func(cmd *cobra.Command, args []string) {
+		catalogs, err := allCatalogs()
+		if err != nil {
+			fmt.Println(Red, "Failed to read catalogs:", err, Reset)
+			return
+		}
+
+		for _, c := range catalogs {
+			packages := "not synced"
+			if filePath, err := catalogPath(c.Name); err == nil {
+				if entries, err := readCatalogFile(filePath); err == nil {
+					packages = fmt.Sprintf("%d packages", len(entries))
+				}
+			}
+
+			signed := "unsigned"
+			if c.Name == mainCatalog || c.Key != "" {
+				signed = "signed"
+			}
+
+			location := c.URL
+			if len(c.Mirrors) > 0 {
+				location += fmt.Sprintf(" (+%d mirrors)", len(c.Mirrors))
+			}
+
+			fmt.Println(Cyan, c.Name, Reset, " - ", "priority", c.Priority, "-", packages, "-", signed, "-", location)
+		}
+
+		// the overlay is applied on top of every catalog
+		if overlay, err := readOverlay(); err == nil && len(overlay) > 0 {
+			path, _ := overlayPath()
+			fmt.Println(Cyan, overlayCatalog, Reset, " - ", len(overlay), "entries - local -", path)
+		}
+	},
+}
+
+// catalogHistoryCmd represents the catalog history command
+var catalogHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the saved versions of a catalog",
+	Long: `List the catalog versions sync saved when it replaced them, newest first
+Usage: ezdeb catalog history [--catalog name]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := cmd.Flag("catalog").Value.String()
+
+		snapshots, err := listSnapshots(name)
+		if err != nil {
+			fmt.Println(Red, "Failed to read catalog history:", err, Reset)
+			return
+		}
+		if len(snapshots) == 0 {
+			fmt.Println("No saved versions of catalog", name)
+			return
+		}
+
+		for i, s := range snapshots {
+			count := "invalid"
+			if entries, err := readCatalogFile(s.path); err == nil {
+				count = fmt.Sprintf("%d packages", len(entries))
+			}
+			fmt.Println(Cyan, i+1, Reset, " - ", s.id, "-", s.time.Local().Format("2006-01-02 15:04:05"), "-", count)
+		}
+	},
+}
+
+// catalogDiffCmd represents the catalog diff command
+var catalogDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the differences between two versions of a catalog",
+	Long: `Show the entries added, removed and changed between two versions of a catalog
+A version is "current", the n-th saved version back or a snapshot id from catalog history.
+Usage: ezdeb catalog diff <a> <b> [--catalog name]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			fmt.Println(Red, "Please provide two catalog versions", Reset)
+			return
+		}
+
+		name := cmd.Flag("catalog").Value.String()
+
+		before, labelA, err := readSnapshot(name, args[0])
+		if err != nil {
+			fmt.Println(Red, "Failed to read version", args[0]+":", err, Reset)
+			return
+		}
+		after, labelB, err := readSnapshot(name, args[1])
+		if err != nil {
+			fmt.Println(Red, "Failed to read version", args[1]+":", err, Reset)
+			return
+		}
+
+		fmt.Printf("Catalog %s: %s -> %s\n", name, labelA, labelB)
+		printCatalogDiffDetails(before, after)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+	catalogCmd.AddCommand(catalogAddCmd)
+	catalogCmd.AddCommand(catalogRemoveCmd)
+	catalogCmd.AddCommand(catalogListCmd)
+	catalogCmd.AddCommand(catalogHistoryCmd)
+	catalogCmd.AddCommand(catalogDiffCmd)
+	withLock(catalogAddCmd)
+	withLock(catalogRemoveCmd)
+
+	catalogAddCmd.Flags().Int("priority", defaultCatalogPriority, "Search order of the catalog, lower is searched first (main is 100)")
+	catalogAddCmd.Flags().String("key", "", "Minisign public key the catalog must be signed with")
+	catalogHistoryCmd.Flags().String("catalog", mainCatalog, "Catalog to show the history of")
+	catalogDiffCmd.Flags().String("catalog", mainCatalog, "Catalog to compare versions of")
+}
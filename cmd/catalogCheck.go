@@ -0,0 +1,178 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// catalogCheckTimeout bounds the time spent resolving one catalog entry
+const catalogCheckTimeout = 60 * time.Second
+
+// assetProbe is what catalog check found out about the .deb of a catalog entry
+type assetProbe struct {
+	release Release
+	status  int
+	size    int64
+	info    *debInfo
+}
+
+func probeAsset(ctx context.Context, pkg *Package) (*assetProbe, error) {
+	// resolve the latest release of an entry and check the control file of its .deb
+	src, err := newSource(pkg)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := src.Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	probe, err := readAssetHeader(ctx, rel)
+	if err != nil {
+		return probe, err
+	}
+	if probe.info.Package != pkg.debName() {
+		return probe, fmt.Errorf("%s contains package %q, expected %q", rel.Asset.Name, probe.info.Package, pkg.debName())
+	}
+	if !archMatches(probe.info.Architecture) {
+		return probe, fmt.Errorf("%s is built for %s, not %s", rel.Asset.Name, probe.info.Architecture, debArch())
+	}
+
+	return probe, nil
+}
+
+func readAssetHeader(ctx context.Context, rel Release) (*assetProbe, error) {
+	// read the control file of the selected .deb of a release
+	// only the start of the .deb is downloaded, up to the control member
+	probe := &assetProbe{release: rel}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rel.Asset.URL, nil)
+	if err != nil {
+		return probe, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return probe, fmt.Errorf("failed to download %s: %v", rel.Asset.Name, err)
+	}
+	defer resp.Body.Close()
+
+	probe.status = resp.StatusCode
+	probe.size = resp.ContentLength
+	if probe.size <= 0 {
+		probe.size = rel.Asset.Size
+	}
+	if resp.StatusCode != http.StatusOK {
+		return probe, fmt.Errorf("download of %s returned %s", rel.Asset.Name, resp.Status)
+	}
+
+	if probe.info, err = readDebInfo(resp.Body); err != nil {
+		return probe, fmt.Errorf("failed to read %s: %v", rel.Asset.Name, err)
+	}
+	return probe, nil
+}
+
+func formatSize(size int64) string {
+	if size <= 0 {
+		return "unknown size"
+	}
+	if size < 1<<20 {
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+}
+
+// catalogCheckCmd represents the catalog check command
+var catalogCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Resolve catalog entries through their source",
+	Long: `Resolve catalog entries through their source and report the .deb that would be installed
+Shows the selected asset, its architecture, size, version and HTTP status.
+Without package names every entry is checked. Exits with status 1 if an entry fails.
+Usage: ezdeb catalog check [pkg...] [--file catalog.json]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var packages []*Package
+
+		if file := cmd.Flag("file").Value.String(); file != "" {
+			// check the entries of a catalog file, e.g. a change that is not published yet
+			data, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Println(Red, "Failed to read", file+":", err, Reset)
+				os.Exit(1)
+			}
+			raw, err := validateCatalog(data)
+			if err != nil {
+				fmt.Println(Red, "Invalid catalog", file+":", err, Reset)
+				os.Exit(1)
+			}
+			for _, entry := range raw {
+				pkg, _ := decodePackage(entry)
+				packages = append(packages, pkg)
+			}
+		} else {
+			for _, entry := range catalogPackages() {
+				packages = append(packages, entry.pkg)
+			}
+		}
+
+		// keep only the packages named on the command line, a missing one counts as failed
+		failed := 0
+		if len(args) > 0 {
+			wanted := make(map[string]bool)
+			for _, arg := range args {
+				_, name := splitPackageName(arg)
+				wanted[name] = true
+			}
+			selected := packages[:0]
+			for _, pkg := range packages {
+				if wanted[pkg.Name] {
+					selected = append(selected, pkg)
+					delete(wanted, pkg.Name)
+				}
+			}
+			packages = selected
+			for name := range wanted {
+				failed++
+				fmt.Println(Red, name+":", "not found in the catalog", Reset)
+			}
+		}
+
+		for _, pkg := range packages {
+			ctx, cancel := context.WithTimeout(context.Background(), catalogCheckTimeout)
+			probe, err := probeAsset(ctx, pkg)
+			cancel()
+
+			if err != nil {
+				failed++
+				fmt.Println(Red, pkg.Name+":", err, Reset)
+				continue
+			}
+
+			version := probe.info.Version
+			if probe.release.Tag != "" {
+				version += " (tag " + probe.release.Tag + ")"
+			}
+			fmt.Println(Green, pkg.Name+":", Reset, probe.release.Asset.Name, "-", probe.info.Architecture, "-", formatSize(probe.size), "-", version, "- HTTP", probe.status)
+		}
+
+		if failed > 0 {
+			fmt.Println(Red, failed, "packages failed", Reset)
+			os.Exit(1)
+		}
+		fmt.Println(Green, "All", len(packages), "packages resolved", Reset)
+	},
+}
+
+func init() {
+	catalogCmd.AddCommand(catalogCheckCmd)
+
+	catalogCheckCmd.Flags().String("file", "", "Check the entries of this catalog file instead of the synced catalogs")
+}
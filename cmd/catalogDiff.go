@@ -0,0 +1,125 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// catalogDiff lists the package names that differ between two versions of a catalog
+type catalogDiff struct {
+	added   []string
+	removed []string
+	changed []string
+}
+
+func (d catalogDiff) empty() bool {
+	return len(d.added) == 0 && len(d.removed) == 0 && len(d.changed) == 0
+}
+
+func diffCatalogs(before []map[string]interface{}, after []map[string]interface{}) catalogDiff {
+	// compare the entries of two catalogs by name
+	oldByName := make(map[string]map[string]interface{})
+	for _, pkg := range before {
+		oldByName[catalogString(pkg, "name")] = pkg
+	}
+
+	var diff catalogDiff
+	seen := make(map[string]bool)
+	for _, pkg := range after {
+		name := catalogString(pkg, "name")
+		seen[name] = true
+		prev, found := oldByName[name]
+		if !found {
+			diff.added = append(diff.added, name)
+		} else if !reflect.DeepEqual(prev, pkg) {
+			diff.changed = append(diff.changed, name)
+		}
+	}
+	for name := range oldByName {
+		if !seen[name] {
+			diff.removed = append(diff.removed, name)
+		}
+	}
+
+	sort.Strings(diff.added)
+	sort.Strings(diff.removed)
+	sort.Strings(diff.changed)
+	return diff
+}
+
+func printCatalogDiff(diff catalogDiff) {
+	if diff.empty() {
+		fmt.Println(" No package changes")
+		return
+	}
+	if len(diff.added) > 0 {
+		fmt.Println(Green, len(diff.added), "added:", strings.Join(diff.added, ", "), Reset)
+	}
+	if len(diff.removed) > 0 {
+		fmt.Println(Red, len(diff.removed), "removed:", strings.Join(diff.removed, ", "), Reset)
+	}
+	if len(diff.changed) > 0 {
+		fmt.Println(Yellow, len(diff.changed), "changed:", strings.Join(diff.changed, ", "), Reset)
+	}
+}
+
+func printCatalogDiffDetails(before []map[string]interface{}, after []map[string]interface{}) {
+	// show the added and removed entries and the fields of every changed entry
+	diff := diffCatalogs(before, after)
+	if diff.empty() {
+		fmt.Println(" No package changes")
+		return
+	}
+
+	byName := func(packages []map[string]interface{}) map[string]map[string]interface{} {
+		m := make(map[string]map[string]interface{})
+		for _, pkg := range packages {
+			m[catalogString(pkg, "name")] = pkg
+		}
+		return m
+	}
+	beforeByName, afterByName := byName(before), byName(after)
+
+	for _, name := range diff.added {
+		fmt.Println(Green, "+", name, Reset)
+	}
+	for _, name := range diff.removed {
+		fmt.Println(Red, "-", name, Reset)
+	}
+	for _, name := range diff.changed {
+		fmt.Println(Yellow, "~", name, Reset)
+
+		old, cur := beforeByName[name], afterByName[name]
+		var fields []string
+		for k := range old {
+			fields = append(fields, k)
+		}
+		for k := range cur {
+			if _, found := old[k]; !found {
+				fields = append(fields, k)
+			}
+		}
+		sort.Strings(fields)
+
+		for _, k := range fields {
+			if reflect.DeepEqual(old[k], cur[k]) {
+				continue
+			}
+			fmt.Printf("     %s: %s -> %s\n", k, diffValue(old, k), diffValue(cur, k))
+		}
+	}
+}
+
+func diffValue(pkg map[string]interface{}, key string) string {
+	value, found := pkg[key]
+	if !found {
+		return "(none)"
+	}
+	return fmt.Sprintf("%v", value)
+}
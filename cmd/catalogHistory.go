@@ -0,0 +1,192 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// catalogHistorySize is the number of replaced catalog versions kept per catalog
+const catalogHistorySize = 10
+
+// snapshotTimeFormat names the snapshots so they sort by time
+const snapshotTimeFormat = "20060102-150405.000"
+
+// catalogSnapshot is a catalog version saved in ~/.ezdeb/catalog-history/<catalog> when it was replaced
+type catalogSnapshot struct {
+	id   string
+	path string
+	time time.Time
+}
+
+func historyDir(name string) (string, error) {
+	dir, err := ezdebDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "catalog-history", name), nil
+}
+
+func listSnapshots(name string) ([]catalogSnapshot, error) {
+	// return the snapshots of a catalog, newest first
+	dir, err := historyDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []catalogSnapshot
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".json")
+		if file.IsDir() || id == file.Name() {
+			continue
+		}
+		t, err := time.Parse(snapshotTimeFormat, id)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, catalogSnapshot{id: id, path: filepath.Join(dir, file.Name()), time: t})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].time.After(snapshots[j].time)
+	})
+	return snapshots, nil
+}
+
+func saveSnapshot(name string, data []byte, sig []byte) error {
+	// keep a catalog version that is about to be replaced, with its signature if it has one
+	// only the newest catalogHistorySize snapshots are kept
+	dir, err := historyDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	snapshotPath := filepath.Join(dir, time.Now().UTC().Format(snapshotTimeFormat)+".json")
+	if err := writeFileAtomic(snapshotPath, data, 0644); err != nil {
+		return err
+	}
+	if sig != nil {
+		if err := writeFileAtomic(snapshotPath+".minisig", sig, 0644); err != nil {
+			return err
+		}
+	}
+
+	snapshots, err := listSnapshots(name)
+	if err != nil {
+		return err
+	}
+	for i := catalogHistorySize; i < len(snapshots); i++ {
+		os.Remove(snapshots[i].path)
+		os.Remove(snapshots[i].path + ".minisig")
+	}
+	return nil
+}
+
+func readSnapshot(name string, ref string) ([]map[string]interface{}, string, error) {
+	// read a catalog version, ref is "current", the n-th snapshot back or a snapshot id
+	// return the entries and a label for the version
+	if ref == "current" {
+		filePath, err := catalogPath(name)
+		if err != nil {
+			return nil, "", err
+		}
+		packages, err := readCatalogFile(filePath)
+		return packages, "current", err
+	}
+
+	snapshots, err := listSnapshots(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(snapshots) {
+			return nil, "", fmt.Errorf("catalog %s has %d snapshots", name, len(snapshots))
+		}
+		packages, err := readCatalogFile(snapshots[n-1].path)
+		return packages, snapshots[n-1].id, err
+	}
+
+	for _, s := range snapshots {
+		if s.id == ref {
+			packages, err := readCatalogFile(s.path)
+			return packages, s.id, err
+		}
+	}
+	return nil, "", fmt.Errorf("snapshot %s of catalog %s not found", ref, name)
+}
+
+func rollbackCatalog(name string, n int) (catalogDiff, string, error) {
+	// restore the n-th snapshot back as the current catalog
+	// the replaced catalog is saved as a snapshot itself so the rollback can be undone
+	snapshots, err := listSnapshots(name)
+	if err != nil {
+		return catalogDiff{}, "", err
+	}
+	if n < 1 || n > len(snapshots) {
+		return catalogDiff{}, "", fmt.Errorf("catalog %s has %d snapshots", name, len(snapshots))
+	}
+	snapshot := snapshots[n-1]
+
+	data, err := os.ReadFile(snapshot.path)
+	if err != nil {
+		return catalogDiff{}, "", err
+	}
+	packages, err := validateCatalog(data)
+	if err != nil {
+		return catalogDiff{}, "", fmt.Errorf("snapshot %s is not valid: %v", snapshot.id, err)
+	}
+	sig, _ := os.ReadFile(snapshot.path + ".minisig")
+
+	filePath, err := catalogPath(name)
+	if err != nil {
+		return catalogDiff{}, "", err
+	}
+	current, err := os.ReadFile(filePath)
+	if err == nil {
+		currentSig, _ := os.ReadFile(filePath + ".minisig")
+		if err := saveSnapshot(name, current, currentSig); err != nil {
+			return catalogDiff{}, "", fmt.Errorf("failed to save the current catalog: %v", err)
+		}
+	}
+
+	if err := writeFileAtomic(filePath, data, 0644); err != nil {
+		return catalogDiff{}, "", fmt.Errorf("failed to write file: %v", err)
+	}
+	if sig != nil {
+		err = writeFileAtomic(filePath+".minisig", sig, 0644)
+	} else {
+		err = os.Remove(filePath + ".minisig")
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return catalogDiff{}, "", fmt.Errorf("failed to store signature: %v", err)
+	}
+
+	// forget the validators so the next sync fetches the remote catalog again
+	os.Remove(filePath + ".cache")
+
+	previous, _ := parseCatalog(current)
+	return diffCatalogs(previous, packages), snapshot.id, nil
+}
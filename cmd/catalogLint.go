@@ -0,0 +1,215 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// debNamePattern is the Debian package name syntax, the name dpkg installs must match it
+var debNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+
+func packageKeys() map[string]bool {
+	// the catalog keys a Package understands
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(Package{})
+	for i := 0; i < t.NumField(); i++ {
+		keys[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	return keys
+}
+
+func checkURL(value string, allowBareHost bool) error {
+	// a catalog url must be absolute https, a bare host name is accepted where the source adds the scheme
+	if allowBareHost && !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("not an http(s) url")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("no host")
+	}
+	if u.Scheme == "http" {
+		return fmt.Errorf("uses http instead of https")
+	}
+	return nil
+}
+
+func lintCatalog(data []byte, overlay bool) []string {
+	// check a catalog file without network access and return every problem found
+	// the overlay can also hide entries, and its entries without a source patch a catalog entry
+	packages, err := parseCatalog(data)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	keys := packageKeys()
+	if overlay {
+		keys["hidden"] = true
+	}
+	seen := make(map[string]int)
+
+	for i, raw := range packages {
+		if raw == nil {
+			problems = append(problems, fmt.Sprintf("entry %d is not an object", i+1))
+			continue
+		}
+		name := catalogString(raw, "name")
+		if name == "" {
+			name = fmt.Sprintf("entry %d", i+1)
+		}
+
+		if first, found := seen[name]; found {
+			problems = append(problems, fmt.Sprintf("%s: duplicate name, first used by entry %d", name, first))
+		} else {
+			seen[name] = i + 1
+		}
+
+		var unknown []string
+		for key := range raw {
+			if !keys[key] {
+				unknown = append(unknown, key)
+			}
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			problems = append(problems, fmt.Sprintf("%s: unknown key %q", name, key))
+		}
+
+		if overlay {
+			hidden, isBool := raw["hidden"].(bool)
+			if _, found := raw["hidden"]; found && !isBool {
+				problems = append(problems, fmt.Sprintf("%s: hidden must be true or false", name))
+			}
+			// hidden entries and patches are not complete entries
+			if hidden || raw["source"] == nil {
+				continue
+			}
+		}
+
+		pkg, errs := decodePackage(raw)
+		for _, err := range errs {
+			problems = append(problems, err.Error())
+		}
+		if pkg == nil {
+			continue
+		}
+
+		if !debNamePattern.MatchString(pkg.debName()) {
+			problems = append(problems, fmt.Sprintf("%s: %s is not a valid Debian package name", pkg.Name, pkg.debName()))
+		}
+		if strings.TrimSpace(pkg.Description) == "" {
+			problems = append(problems, fmt.Sprintf("%s: missing description", pkg.Name))
+		}
+
+		urls := []struct {
+			key           string
+			value         string
+			allowBareHost bool
+		}{
+			{"link", pkg.Link, false},
+			{"gitlab_url", pkg.GitlabURL, false},
+			{"host", pkg.Host, true},
+			{"repo_url", pkg.RepoURL, false},
+		}
+		for _, u := range urls {
+			if u.value == "" {
+				continue
+			}
+			if err := checkURL(u.value, u.allowBareHost); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: bad %s %q: %v", pkg.Name, u.key, u.value, err))
+			}
+		}
+		for _, key := range []string{"gpg_key", "cosign_key"} {
+			if value := pkg.field(key); strings.HasPrefix(value, "http://") {
+				problems = append(problems, fmt.Sprintf("%s: %s must be fetched over https", pkg.Name, key))
+			}
+		}
+	}
+
+	return problems
+}
+
+// catalogLintCmd represents the catalog lint command
+var catalogLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check catalog files for mistakes",
+	Long: `Check catalog files for mistakes without network access
+Reports duplicate names, missing fields per source, bad urls and unknown keys.
+Without files the synced catalogs and the overlay are checked. Exits with status 1 if a problem is found.
+Usage: ezdeb catalog lint [file...]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		files := args
+		if len(files) == 0 {
+			catalogs, err := allCatalogs()
+			if err != nil {
+				fmt.Println(Red, "Failed to read catalogs:", err, Reset)
+				os.Exit(1)
+			}
+			for _, c := range catalogs {
+				if filePath, err := catalogPath(c.Name); err == nil {
+					if _, err := os.Stat(filePath); err == nil {
+						files = append(files, filePath)
+					}
+				}
+			}
+			if filePath, err := overlayPath(); err == nil {
+				if _, err := os.Stat(filePath); err == nil {
+					files = append(files, filePath)
+				}
+			}
+		}
+
+		// the overlay is checked as patches on the catalogs
+		var overlayInfo os.FileInfo
+		if filePath, err := overlayPath(); err == nil {
+			overlayInfo, _ = os.Stat(filePath)
+		}
+
+		total := 0
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Println(Red, "Failed to read", file+":", err, Reset)
+				total++
+				continue
+			}
+
+			isOverlay := false
+			if info, err := os.Stat(file); err == nil && overlayInfo != nil {
+				isOverlay = os.SameFile(info, overlayInfo)
+			}
+			problems := lintCatalog(data, isOverlay)
+			for _, problem := range problems {
+				fmt.Println(Red, file+":", problem, Reset)
+			}
+			total += len(problems)
+		}
+
+		if total > 0 {
+			fmt.Println(Red, "Found", total, "problems", Reset)
+			os.Exit(1)
+		}
+		fmt.Println(Green, "No problems found in", len(files), "catalogs", Reset)
+	},
+}
+
+func init() {
+	catalogCmd.AddCommand(catalogLintCmd)
+}
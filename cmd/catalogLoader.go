@@ -0,0 +1,321 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// mainCatalog is the name of the upstream package list
+	mainCatalog = "main"
+	// mainCatalogPriority is the priority of the upstream package list, lower is consulted first
+	mainCatalogPriority = 100
+	// defaultCatalogPriority puts added catalogs after the upstream one
+	defaultCatalogPriority = 200
+)
+
+var catalogNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// catalogConfig is a package list ezdeb syncs, stored in ~/.ezdeb/catalogs.json
+type catalogConfig struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Priority int    `json:"priority"`
+	// Mirrors are tried in order when the catalog can't be synced from URL
+	Mirrors []string `json:"mirrors,omitempty"`
+	// Key is an optional minisign public key the catalog must be signed with
+	Key string `json:"key,omitempty"`
+}
+
+// catalogEntry is a package entry together with the catalog it came from
+type catalogEntry struct {
+	catalog string
+	// fields is the raw entry the overlay is merged into, pkg is decoded from it
+	fields map[string]interface{}
+	pkg    *Package
+	// overlaid lists the fields set by ~/.ezdeb/overlay.json
+	overlaid []string
+}
+
+// catalogProblemsReported is set once the invalid catalog entries were shown, so they are shown once per run
+var catalogProblemsReported bool
+
+func (e catalogEntry) name() string {
+	return catalogString(e.fields, "name")
+}
+
+func (e catalogEntry) qualifiedName() string {
+	return e.catalog + "/" + e.name()
+}
+
+func ezdebDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ezdeb"), nil
+}
+
+func catalogPath(name string) (string, error) {
+	// the upstream list keeps its historic location, added catalogs live in ~/.ezdeb/catalogs
+	dir, err := ezdebDir()
+	if err != nil {
+		return "", err
+	}
+	if name == mainCatalog {
+		return filepath.Join(dir, "pkglist.json"), nil
+	}
+	return filepath.Join(dir, "catalogs", name+".json"), nil
+}
+
+func readCatalogConfigs() ([]catalogConfig, error) {
+	// return the added catalogs, without the upstream one
+	dir, err := ezdebDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "catalogs.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Catalogs []catalogConfig `json:"catalogs"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse catalogs.json: %v", err)
+	}
+	return file.Catalogs, nil
+}
+
+func writeCatalogConfigs(catalogs []catalogConfig) error {
+	dir, err := ezdebDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Catalogs []catalogConfig `json:"catalogs"`
+	}{catalogs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "catalogs.json"), append(data, '\n'), 0644)
+}
+
+func allCatalogs() ([]catalogConfig, error) {
+	// return the upstream catalog and the added ones in priority order
+	added, err := readCatalogConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	urls := catalogURLs()
+	upstream := catalogConfig{Name: mainCatalog, URL: urls[0], Mirrors: urls[1:], Priority: mainCatalogPriority}
+
+	catalogs := append([]catalogConfig{upstream}, added...)
+	sort.SliceStable(catalogs, func(i, j int) bool {
+		return catalogs[i].Priority < catalogs[j].Priority
+	})
+	return catalogs, nil
+}
+
+func readCatalogFile(path string) ([]map[string]interface{}, error) {
+	// read the package entries of a synced catalog file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	packages, err := parseCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return packages, nil
+}
+
+// mainCatalogSyncTried is set once ensureMainCatalog tried to sync a missing package list
+var mainCatalogSyncTried bool
+
+func ensureMainCatalog() {
+	// sync the package list the first time a command needs it
+	filePath, err := catalogPath(mainCatalog)
+	if err != nil || mainCatalogSyncTried {
+		return
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		return
+	}
+	mainCatalogSyncTried = true
+
+	// the list is only written under the ezdeb lock, commands that don't hold it take it here
+	// if another ezdeb holds it the list is not synced unless --wait is set, that ezdeb or the next command syncs it
+	if !lockHeld {
+		release, err := acquireLock(settings.GetBool("wait"))
+		if err != nil {
+			fmt.Println(Yellow, "Package list does not exist and can't be synced now:", err, Reset)
+			return
+		}
+		defer release()
+		// another ezdeb may have synced it before we got the lock
+		if _, err := os.Stat(filePath); err == nil {
+			return
+		}
+	}
+
+	fmt.Println(Yellow, "******\n\nPackage list does not exist.\nSyncing package list from repository.\n\n******", Reset)
+	c, err := findCatalog(mainCatalog)
+	if err != nil {
+		fmt.Println(Red, "Failed to sync catalog", mainCatalog+":", err, Reset)
+		return
+	}
+	result, err := syncCatalog(context.Background(), c)
+	if err != nil {
+		fmt.Println(Red, "Failed to sync catalog", mainCatalog+":", err, Reset)
+		return
+	}
+	printCatalogSync(mainCatalog, result)
+}
+
+func loadCatalogEntries() ([]catalogEntry, error) {
+	// return the entries of every synced catalog in priority order with the user overlay applied
+	// catalogs that were added but not synced yet are skipped
+	// invalid catalogs and entries are skipped and reported instead of failing every command
+	ensureMainCatalog()
+
+	catalogs, err := allCatalogs()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	var entries []catalogEntry
+	for _, c := range catalogs {
+		path, err := catalogPath(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		packages, err := readCatalogFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("catalog %s: %v", c.Name, err))
+			continue
+		}
+		for _, pkg := range packages {
+			if pkg != nil {
+				entries = append(entries, catalogEntry{catalog: c.Name, fields: pkg})
+			}
+		}
+	}
+
+	overlay, err := readOverlay()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("overlay: %v", err))
+	}
+
+	var valid []catalogEntry
+	for _, e := range applyOverlay(entries, overlay) {
+		pkg, errs := decodePackage(e.fields)
+		for _, err := range errs {
+			problems = append(problems, fmt.Sprintf("catalog %s: %v", e.catalog, err))
+		}
+		if pkg != nil {
+			e.pkg = pkg
+			valid = append(valid, e)
+		}
+	}
+
+	if len(problems) > 0 && !catalogProblemsReported {
+		catalogProblemsReported = true
+		fmt.Println(Yellow, "Skipping invalid catalog entries:", Reset)
+		for _, problem := range problems {
+			fmt.Println(Yellow, "  ", problem, Reset)
+		}
+	}
+	return valid, nil
+}
+
+func catalogPackages() []catalogEntry {
+	// return the merged package list, a name shadowed by a higher priority catalog is left out
+	entries, err := loadCatalogEntries()
+	if err != nil {
+		fmt.Println(Red, "Failed to load package lists:", err, Reset)
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var merged []catalogEntry
+	for _, e := range entries {
+		if seen[e.name()] {
+			continue
+		}
+		seen[e.name()] = true
+		merged = append(merged, e)
+	}
+	return merged
+}
+
+func splitPackageName(pkgName string) (string, string) {
+	// split catalog/package into its parts, the catalog is empty for a plain name
+	if i := strings.Index(pkgName, "/"); i >= 0 {
+		return pkgName[:i], pkgName[i+1:]
+	}
+	return "", pkgName
+}
+
+func lookupPackage(pkgName string) (*Package, bool) {
+	// search for the package in the package lists
+	// a plain name is taken from the highest priority catalog that has it
+	// catalog/package picks it from that catalog
+	entry, found := lookupCatalogEntry(pkgName)
+	return entry.pkg, found
+}
+
+func lookupCatalogEntry(pkgName string) (catalogEntry, bool) {
+	catalog, name := splitPackageName(pkgName)
+
+	entries, err := loadCatalogEntries()
+	if err != nil {
+		fmt.Println(Red, "Failed to load package lists:", err, Reset)
+		return catalogEntry{}, false
+	}
+
+	for _, e := range entries {
+		if e.name() == name && (catalog == "" || e.catalog == catalog) {
+			return e, true
+		}
+	}
+	return catalogEntry{}, false
+}
+
+func findCatalog(name string) (catalogConfig, error) {
+	catalogs, err := allCatalogs()
+	if err != nil {
+		return catalogConfig{}, err
+	}
+	for _, c := range catalogs {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return catalogConfig{}, fmt.Errorf("catalog %s does not exist", name)
+}
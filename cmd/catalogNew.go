@@ -0,0 +1,212 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// versionRunPattern matches the version numbers in an asset name, e.g. 1.2.3
+var versionRunPattern = regexp.MustCompile(`[0-9]+(\\\.[0-9]+)+`)
+
+func parseEntrySpec(spec string, gitlabURL string) (*Package, error) {
+	// turn github:owner/repo, gitlab:group/project or url:https://... into a catalog entry to probe
+	kind, location, found := strings.Cut(spec, ":")
+	if !found || location == "" {
+		return nil, fmt.Errorf("expected github:owner/repo, gitlab:group/project or url:link, got %q", spec)
+	}
+
+	switch kind {
+	case "github":
+		owner, repo, found := strings.Cut(location, "/")
+		if !found || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return nil, fmt.Errorf("expected github:owner/repo, got %q", spec)
+		}
+		return &Package{Name: strings.ToLower(repo), Source: "github", GHUser: owner, GHRepo: repo}, nil
+	case "gitlab":
+		project := strings.Trim(location, "/")
+		if !strings.Contains(project, "/") {
+			return nil, fmt.Errorf("expected gitlab:group/project, got %q", spec)
+		}
+		return &Package{Name: strings.ToLower(filepath.Base(project)), Source: "gitlab", GitlabProject: project, GitlabURL: gitlabURL}, nil
+	case "url":
+		if err := checkURL(location, false); err != nil {
+			return nil, fmt.Errorf("bad url %q: %v", location, err)
+		}
+		return &Package{Name: strings.ToLower(strings.TrimSuffix(filepath.Base(location), ".deb")), Source: "website", Link: location}, nil
+	}
+
+	return nil, fmt.Errorf("unknown source %q, use github, gitlab or url", kind)
+}
+
+func assetNamePattern(name string) string {
+	// generalize an asset name into a pattern that keeps matching when the version changes
+	return "^" + versionRunPattern.ReplaceAllString(regexp.QuoteMeta(name), ".*") + "$"
+}
+
+func matchingAssets(pattern string, assets []Asset) int {
+	// count the assets a pattern selects
+	re := regexp.MustCompile(pattern)
+	count := 0
+	for _, a := range assets {
+		if re.MatchString(a.Name) {
+			count++
+		}
+	}
+	return count
+}
+
+func suggestAssetRules(pkg *Package, rel Release) []Asset {
+	// fill in asset_pattern or asset_arch when a release offers several .deb files
+	// return the .deb files found
+	var debs []Asset
+	for _, a := range rel.Assets {
+		if filepath.Ext(a.Name) == ".deb" {
+			debs = append(debs, a)
+		}
+	}
+	if len(debs) < 2 {
+		return debs
+	}
+
+	// one pattern per architecture if the file names name one
+	if assetArch(rel.Asset.Name) != "" {
+		byArch := make(map[string]Asset)
+		for _, a := range debs {
+			arch := assetArch(a.Name)
+			if arch == "" {
+				continue
+			}
+			// like selectDebAsset, prefer the shortest name of each architecture
+			if current, found := byArch[arch]; !found || len(a.Name) < len(current.Name) {
+				byArch[arch] = a
+			}
+		}
+
+		rules := make(map[string]string)
+		for arch, a := range byArch {
+			pattern := assetNamePattern(a.Name)
+			if matchingAssets(pattern, debs) != 1 {
+				// the generalized name is ambiguous, leave the choice to selectDebAsset
+				return debs
+			}
+			rules[arch] = pattern
+		}
+		pkg.AssetArch = rules
+		return debs
+	}
+
+	if pattern := assetNamePattern(rel.Asset.Name); matchingAssets(pattern, debs) == 1 {
+		pkg.AssetPattern = pattern
+	}
+	return debs
+}
+
+// catalogNewCmd represents the catalog new command
+var catalogNewCmd = &cobra.Command{
+	Use:   "new",
+	Short: "Generate a catalog entry from a release",
+	Long: `Generate a catalog entry by probing the latest release of a project
+The .deb header is downloaded to read the real package name and description.
+asset_pattern or asset_arch rules are suggested when the release has several .deb files.
+Prints the entry as JSON, with --overlay it is added to the local overlay catalog.
+Usage: ezdeb catalog new github:owner/repo | gitlab:group/project | url:https://example.com/app.deb [--overlay]`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pkg, err := parseEntrySpec(args[0], cmd.Flag("gitlab-url").Value.String())
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), catalogCheckTimeout)
+		defer cancel()
+
+		src, err := newSource(pkg)
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+		fmt.Println(Cyan, "Probing", src.String(), Reset)
+
+		rel, err := src.Latest(ctx)
+		if err != nil {
+			fmt.Println(Red, "Failed to resolve the latest release:", err, Reset)
+			os.Exit(1)
+		}
+		probe, err := readAssetHeader(ctx, rel)
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+
+		// the catalog name must be the name dpkg installs
+		pkg.Name = probe.info.Package
+		pkg.Description, _, _ = strings.Cut(probe.info.Description, "\n")
+
+		debs := suggestAssetRules(pkg, rel)
+		if len(debs) > 1 {
+			fmt.Println(Cyan, "Found", len(debs), ".deb files:", Reset)
+			for _, a := range debs {
+				fmt.Println("   ", a.Name)
+			}
+			switch {
+			case len(pkg.AssetArch) > 0:
+				fmt.Println(Cyan, "Suggested asset_arch rules for", len(pkg.AssetArch), "architectures", Reset)
+			case pkg.AssetPattern != "":
+				fmt.Println(Cyan, "Suggested asset_pattern", pkg.AssetPattern, Reset)
+			default:
+				fmt.Println(Yellow, "Could not suggest a pattern, check which asset gets selected", Reset)
+			}
+		}
+		fmt.Println(Cyan, "Selected", rel.Asset.Name, "-", probe.info.Architecture, "-", probe.info.Version, Reset)
+
+		if errs := pkg.validate(); len(errs) > 0 {
+			fmt.Println(Red, catalogErrors(errs), Reset)
+			os.Exit(1)
+		}
+		if entry, found := lookupCatalogEntry(pkg.Name); found {
+			fmt.Println(Yellow, "Catalog", entry.catalog, "already has an entry for", pkg.Name, Reset)
+		}
+
+		data, err := json.MarshalIndent(pkg, "", "  ")
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+
+		if overlay, _ := cmd.Flags().GetBool("overlay"); overlay {
+			// the probe above runs unlocked, only the overlay write needs the ezdeb lock
+			release, err := acquireLock(waitForLock(cmd))
+			if err != nil {
+				fmt.Println(Red, err, Reset)
+				os.Exit(1)
+			}
+			defer release()
+
+			if err := appendOverlay(pkg); err != nil {
+				fmt.Println(Red, "Failed to add the entry to the overlay:", err, Reset)
+				os.Exit(1)
+			}
+			fmt.Println(Green, "Added", pkg.Name, "to the overlay catalog", Reset)
+		}
+	},
+}
+
+func init() {
+	catalogCmd.AddCommand(catalogNewCmd)
+
+	catalogNewCmd.Flags().Bool("overlay", false, "Add the entry to the local overlay catalog")
+	catalogNewCmd.Flags().String("gitlab-url", "", "Base url of a self-hosted GitLab instance")
+}
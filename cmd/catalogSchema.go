@@ -0,0 +1,210 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// catalogSchemaVersion is the newest catalog format this ezdeb understands
+// catalogs without schema_version are version 1
+const catalogSchemaVersion = 2
+
+// Package is a catalog entry, the fields used depend on Source
+type Package struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Source      string `json:"source"`
+
+	// github
+	GHUser string `json:"ghuser,omitempty"`
+	GHRepo string `json:"ghrepo,omitempty"`
+
+	// website
+	Link string `json:"link,omitempty"`
+
+	// gitlab
+	GitlabProject string `json:"gitlab_project,omitempty"`
+	GitlabURL     string `json:"gitlab_url,omitempty"`
+
+	// gitea
+	Host  string `json:"host,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	Repo  string `json:"repo,omitempty"`
+
+	// aptrepo
+	RepoURL    string `json:"repo_url,omitempty"`
+	Suite      string `json:"suite,omitempty"`
+	Component  string `json:"component,omitempty"`
+	AptPackage string `json:"package,omitempty"`
+
+	// asset selection, see assetRules
+	AssetPattern string            `json:"asset_pattern,omitempty"`
+	AssetExclude string            `json:"asset_exclude,omitempty"`
+	AssetArch    map[string]string `json:"asset_arch,omitempty"`
+
+	// verification, see fetchRelease and verifySignature
+	SHA256         string `json:"sha256,omitempty"`
+	GPGFingerprint string `json:"gpg_fingerprint,omitempty"`
+	GPGKey         string `json:"gpg_key,omitempty"`
+	MinisignKey    string `json:"minisign_key,omitempty"`
+	CosignKey      string `json:"cosign_key,omitempty"`
+}
+
+// catalogUpgrades turn an entry of a catalog version into an entry of the next version
+var catalogUpgrades = map[int]func(pkg map[string]interface{}){
+	// version 1 website entries could use "url" for the link
+	1: func(pkg map[string]interface{}) {
+		if _, found := pkg["link"]; !found && catalogString(pkg, "source") == "website" {
+			if url, found := pkg["url"]; found {
+				pkg["link"] = url
+				delete(pkg, "url")
+			}
+		}
+	},
+}
+
+// catalogErrors lists every problem found in a catalog
+type catalogErrors []error
+
+func (e catalogErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d problems:\n   %s", len(e), strings.Join(lines, "\n   "))
+}
+
+func parseCatalog(data []byte) ([]map[string]interface{}, error) {
+	// read the entries of a catalog file and upgrade them to the current schema version
+	var file struct {
+		SchemaVersion *int                      `json:"schema_version"`
+		Packages      *[]map[string]interface{} `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	if file.Packages == nil {
+		return nil, fmt.Errorf("no packages list")
+	}
+
+	version := 1
+	if file.SchemaVersion != nil {
+		version = *file.SchemaVersion
+	}
+	if version < 1 || version > catalogSchemaVersion {
+		return nil, fmt.Errorf("schema version %d is not supported, this ezdeb understands up to %d, update ezdeb", version, catalogSchemaVersion)
+	}
+
+	packages := *file.Packages
+	for ; version < catalogSchemaVersion; version++ {
+		for _, pkg := range packages {
+			if pkg != nil {
+				catalogUpgrades[version](pkg)
+			}
+		}
+	}
+	return packages, nil
+}
+
+func decodePackage(raw map[string]interface{}) (*Package, []error) {
+	// turn a catalog entry into a Package and check it, every problem is named with the entry
+	name := catalogString(raw, "name")
+	if name == "" {
+		return nil, []error{fmt.Errorf("entry without a name")}
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", name, err)}
+	}
+	var pkg Package
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", name, err)}
+	}
+
+	if errs := pkg.validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return &pkg, nil
+}
+
+func validateCatalog(data []byte) ([]map[string]interface{}, error) {
+	// check a downloaded catalog before it replaces the synced one
+	// return the upgraded entries, or every problem found in them
+	packages, err := parseCatalog(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs catalogErrors
+	for i, raw := range packages {
+		if raw == nil {
+			errs = append(errs, fmt.Errorf("entry %d is not an object", i+1))
+			continue
+		}
+		_, pkgErrs := decodePackage(raw)
+		errs = append(errs, pkgErrs...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return packages, nil
+}
+
+func (p *Package) validate() []error {
+	// check the fields every entry needs and the ones its source type needs
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]interface{}{p.Name}, args...)...))
+	}
+
+	if strings.Contains(p.Name, "/") {
+		fail("name must not contain /")
+	}
+	if p.Source == "" {
+		fail("missing field %q", "source")
+	} else if _, ok := sourceFactories[p.Source]; !ok {
+		fail("unknown source type %q", p.Source)
+	}
+	for _, field := range sourceFields[p.Source] {
+		if p.field(field) == "" {
+			fail("missing field %q for source %s", field, p.Source)
+		}
+	}
+
+	if _, err := newAssetRules(p); err != nil {
+		errs = append(errs, err)
+	}
+	if p.SHA256 != "" && !sha256Pattern.MatchString(p.SHA256) {
+		fail("sha256 is not a SHA256 checksum")
+	}
+
+	return errs
+}
+
+func (p *Package) field(key string) string {
+	// get a string field by its catalog key
+	v := reflect.ValueOf(p).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == key && v.Field(i).Kind() == reflect.String {
+			return v.Field(i).String()
+		}
+	}
+	return ""
+}
+
+func (p *Package) debName() string {
+	// the name dpkg installs the package as, an apt repository entry can name a package other than the entry
+	if p.Source == "aptrepo" && p.AptPackage != "" {
+		return p.AptPackage
+	}
+	return p.Name
+}
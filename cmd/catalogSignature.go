@@ -0,0 +1,103 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// catalogPublicKey is the minisign key the package list is signed with
+// release builds embed it with -ldflags "-X ezdeb/cmd.catalogPublicKey=<key>"
+var catalogPublicKey string
+
+// allowUnsigned is set by sync --allow-unsigned to accept a package list without a valid signature
+var allowUnsigned bool
+
+func parseMinisignPublicKey(data string) string {
+	// a minisign .pub file has an untrusted comment line followed by the key
+	key := ""
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			key = line
+		}
+	}
+	return key
+}
+
+func catalogKey() (string, string, error) {
+	// return the key the package list must be signed with and where it came from
+	// a key configured in ~/.ezdeb/catalog.pub wins over the embedded one
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	keyPath := filepath.Join(homeDir, ".ezdeb", "catalog.pub")
+	if data, err := os.ReadFile(keyPath); err == nil {
+		if key := parseMinisignPublicKey(string(data)); key != "" {
+			return key, keyPath, nil
+		}
+		return "", "", fmt.Errorf("no key found in %s", keyPath)
+	}
+
+	if catalogPublicKey != "" {
+		return catalogPublicKey, "embedded", nil
+	}
+
+	return "", "", fmt.Errorf("no catalog key embedded or configured in %s", keyPath)
+}
+
+func catalogKeyFor(c catalogConfig) (string, string, error) {
+	// the upstream list uses the publisher key, added catalogs the key given to catalog add
+	if c.Name == mainCatalog {
+		return catalogKey()
+	}
+	if c.Key == "" {
+		return "", "", fmt.Errorf("no key configured for catalog %s", c.Name)
+	}
+	return parseMinisignPublicKey(c.Key), "catalog " + c.Name, nil
+}
+
+func verifyCatalog(c catalogConfig, data []byte, sig []byte) (string, error) {
+	// check the minisign signature of a package list, return the key that signed it
+	key, origin, err := catalogKeyFor(c)
+	if err != nil {
+		return "", err
+	}
+
+	keyID, err := minisignVerify(key, data, sig)
+	if err != nil {
+		return "", fmt.Errorf("package list signature is not valid: %v", err)
+	}
+
+	return fmt.Sprintf("minisign %s (%s key)", keyID, origin), nil
+}
+
+func catalogSigner(name string) (string, error) {
+	// verify a synced package list against its stored signature
+	c, err := findCatalog(name)
+	if err != nil {
+		return "", err
+	}
+
+	listPath, err := catalogPath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(listPath)
+	if err != nil {
+		return "", err
+	}
+	sig, err := os.ReadFile(listPath + ".minisig")
+	if err != nil {
+		return "", fmt.Errorf("package list is not signed")
+	}
+
+	return verifyCatalog(c, data, sig)
+}
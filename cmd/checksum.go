@@ -0,0 +1,122 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// requireChecksum is set by --require-checksum to refuse packages without a known checksum
+var requireChecksum bool
+
+// checksumFilePattern matches release files that list checksums of the other files
+var checksumFilePattern = regexp.MustCompile(`(?i)(^|[._-])(sha256sums?|checksums?)(\.txt)?$|\.sha256(sum)?$`)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+func checksumFiles(rel Release) []Asset {
+	// list the checksum files of a release, files made for the selected asset first
+	var own, shared []Asset
+	for _, a := range rel.Assets {
+		if !checksumFilePattern.MatchString(a.Name) {
+			continue
+		}
+		if strings.HasPrefix(a.Name, rel.Asset.Name+".") {
+			own = append(own, a)
+		} else if !strings.HasSuffix(a.Name, ".sha256") && !strings.HasSuffix(a.Name, ".sha256sum") {
+			// a .sha256 file next to another asset says nothing about ours
+			shared = append(shared, a)
+		}
+	}
+	return append(own, shared...)
+}
+
+func parseChecksumFile(r io.Reader, name string) string {
+	// find the SHA256 of name in a checksum file
+	// understands "hash  name", "hash *name", "SHA256 (name) = hash" and a file holding only the hash
+	scanner := bufio.NewScanner(r)
+	lines := 0
+	single := ""
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines++
+
+		// BSD style
+		if strings.HasPrefix(line, "SHA256 (") {
+			if i := strings.LastIndex(line, ") = "); i >= 0 && line[len("SHA256 ("):i] == name {
+				return strings.ToLower(line[i+len(") = "):])
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !sha256Pattern.MatchString(fields[0]) {
+			continue
+		}
+		if len(fields) == 1 {
+			single = fields[0]
+			continue
+		}
+		file := strings.TrimPrefix(fields[len(fields)-1], "*")
+		if file == name || strings.HasSuffix(file, "/"+name) {
+			return strings.ToLower(fields[0])
+		}
+	}
+
+	if lines == 1 && single != "" {
+		return strings.ToLower(single)
+	}
+	return ""
+}
+
+func findChecksum(ctx context.Context, src Source, rel Release) (string, string, error) {
+	// look for the expected SHA256 of the selected asset
+	// return the checksum and where it came from, empty if the release publishes none
+	if rel.SHA256 != "" {
+		return strings.ToLower(rel.SHA256), "source index", nil
+	}
+
+	for _, a := range checksumFiles(rel) {
+		// checksum files come from the source, which authenticates to private projects
+		data, status, err := src.Fetch(ctx, a.URL)
+		if status != 0 && status != http.StatusOK {
+			continue
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to download %s: %v", a.Name, err)
+		}
+
+		if sum := parseChecksumFile(bytes.NewReader(data), rel.Asset.Name); sum != "" {
+			return sum, a.Name, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+func verifyChecksum(location string, name string, expected string) (string, error) {
+	// compare the SHA256 of a downloaded file with the expected one and return the actual checksum
+	sum, err := sha256File(location)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %v", name, err)
+	}
+
+	if expected != "" && !strings.EqualFold(sum, expected) {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, strings.ToLower(expected), sum)
+	}
+
+	return sum, nil
+}
@@ -55,4 +55,5 @@ Usage: ezdeb clean`,
 
 func init() {
 	rootCmd.AddCommand(cleanCmd)
+	withLock(cleanCmd)
 }
@@ -0,0 +1,70 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const defaultVersionURL = "https://gitlab.com/Charlie-117/ezdeb/-/raw/master/release/version"
+
+// settings is the ezdeb configuration
+// flags win over EZDEB_* environment variables, which win over ~/.ezdeb/config.json
+var settings = viper.New()
+
+func initSettings() {
+	settings.SetDefault("catalog_urls", []string{catalogURL})
+	settings.SetDefault("version_url", defaultVersionURL)
+	settings.SetDefault("wait", false)
+
+	// EZDEB_CATALOG_URLS, EZDEB_VERSION_URL, EZDEB_WAIT
+	settings.SetEnvPrefix("ezdeb")
+	settings.AutomaticEnv()
+
+	settings.BindPFlag("catalog_urls", rootCmd.PersistentFlags().Lookup("catalog-url"))
+	settings.BindPFlag("version_url", rootCmd.PersistentFlags().Lookup("version-url"))
+	settings.BindPFlag("wait", rootCmd.PersistentFlags().Lookup("wait"))
+
+	dir, err := ezdebDir()
+	if err != nil {
+		return
+	}
+	settings.SetConfigName("config")
+	settings.AddConfigPath(dir)
+	if err := settings.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			fmt.Println(Yellow, "Failed to read config:", err, Reset)
+		}
+	}
+}
+
+func catalogURLs() []string {
+	// the upstream catalog locations in the order they are tried
+	// an environment variable lists them separated by spaces or commas
+	var urls []string
+	for _, value := range settings.GetStringSlice("catalog_urls") {
+		for _, url := range strings.Split(value, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return []string{catalogURL}
+	}
+	return urls
+}
+
+func versionURL() string {
+	// an empty url or "none" turns the update check off
+	url := strings.TrimSpace(settings.GetString("version_url"))
+	if url == "none" {
+		return ""
+	}
+	return url
+}
@@ -0,0 +1,234 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+)
+
+// debInfo holds the fields of a .deb control file that ezdeb cares about
+type debInfo struct {
+	Package       string
+	Version       string
+	Architecture  string
+	Depends       string
+	InstalledSize string
+	Maintainer    string
+	// Description is the synopsis line followed by the extended description
+	Description string
+}
+
+func readDebFile(location string) (*debInfo, error) {
+	// read the control metadata of a .deb file on disk
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", location, err)
+	}
+	defer f.Close()
+
+	info, err := readDebInfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path.Base(location), err)
+	}
+	return info, nil
+}
+
+func readDebInfo(r io.Reader) (*debInfo, error) {
+	// a .deb is an ar archive holding debian-binary, control.tar.* and data.tar.*
+	// the members are read in order so we can stop as soon as the control file was found
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("not a debian package: missing ar header")
+	}
+
+	header := make([]byte, arHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("no control archive found")
+			}
+			return nil, fmt.Errorf("failed to read ar header: %v", err)
+		}
+		if string(header[58:60]) != "`\n" {
+			return nil, fmt.Errorf("corrupt ar header")
+		}
+
+		// GNU ar terminates member names with a slash
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size for ar member %s", name)
+		}
+
+		member := io.LimitReader(br, size)
+
+		if strings.HasPrefix(name, "control.tar") {
+			control, err := readControlTar(name, member)
+			if err != nil {
+				return nil, err
+			}
+			return parseControl(control)
+		}
+
+		// skip the member and the padding byte that keeps members 2-byte aligned
+		if _, err := io.Copy(io.Discard, member); err != nil {
+			return nil, fmt.Errorf("failed to skip ar member %s: %v", name, err)
+		}
+		if size%2 == 1 {
+			if _, err := br.ReadByte(); err != nil && err != io.EOF {
+				return nil, fmt.Errorf("failed to read ar padding: %v", err)
+			}
+		}
+	}
+}
+
+func readControlTar(name string, r io.Reader) ([]byte, error) {
+	// decompress the control archive based on its extension and return the control file
+	var tr *tar.Reader
+
+	switch name {
+	case "control.tar":
+		tr = tar.NewReader(r)
+	case "control.tar.gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+		}
+		defer gz.Close()
+		tr = tar.NewReader(gz)
+	case "control.tar.xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+		}
+		tr = tar.NewReader(xr)
+	case "control.tar.zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+		}
+		defer zr.Close()
+		tr = tar.NewReader(zr)
+	default:
+		return nil, fmt.Errorf("unsupported control archive %s", name)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no control file in %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		if path.Clean(strings.TrimPrefix(hdr.Name, "./")) == "control" {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+func parseDeb822(r io.Reader) ([]map[string]string, error) {
+	// parse the "Field: value" paragraphs used by control files and apt indexes
+	// continuation lines start with a space and belong to the previous field
+	// paragraphs are separated by blank lines, field names are lower cased
+	var paragraphs []map[string]string
+	var fields map[string]string
+	last := ""
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			fields, last = nil, ""
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if last != "" {
+				fields[last] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed control line %q", line)
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+			paragraphs = append(paragraphs, fields)
+		}
+		last = strings.ToLower(strings.TrimSpace(line[:i]))
+		fields[last] = strings.TrimSpace(line[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read control data: %v", err)
+	}
+
+	return paragraphs, nil
+}
+
+func parseControl(data []byte) (*debInfo, error) {
+	// a control file is a single paragraph
+	paragraphs, err := parseDeb822(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(paragraphs) == 0 {
+		return nil, fmt.Errorf("control file is empty")
+	}
+	fields := paragraphs[0]
+
+	info := &debInfo{
+		Package:       fields["package"],
+		Version:       fields["version"],
+		Architecture:  fields["architecture"],
+		Depends:       fields["depends"],
+		InstalledSize: fields["installed-size"],
+		Maintainer:    fields["maintainer"],
+		Description:   fields["description"],
+	}
+
+	if info.Package == "" || info.Version == "" {
+		return nil, fmt.Errorf("control file is missing Package or Version")
+	}
+	return info, nil
+}
+
+func verifyDebPackage(location string, pkg string) (*debInfo, error) {
+	// make sure the downloaded file is the package the catalog promised
+	info, err := readDebFile(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Package != pkg {
+		return nil, fmt.Errorf("downloaded package is %s, expected %s", info.Package, pkg)
+	}
+
+	if !archMatches(info.Architecture) {
+		return nil, fmt.Errorf("downloaded package is built for %s, expected %s", info.Architecture, debArch())
+	}
+
+	return info, nil
+}
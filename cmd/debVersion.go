@@ -0,0 +1,214 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// preReleasePattern matches the hyphen of a pre-release suffix in a tag, e.g. 1.2.0-rc1
+var preReleasePattern = regexp.MustCompile(`(?i)-(alpha|beta|rc|pre|dev)`)
+
+// debVersion holds the three parts of a Debian version string
+// [epoch:]upstream_version[-debian_revision]
+type debVersion struct {
+	epoch    int
+	upstream string
+	revision string
+}
+
+func parseDebVersion(version string) (debVersion, error) {
+	var v debVersion
+
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return v, fmt.Errorf("empty version string")
+	}
+
+	// the epoch is everything before the first colon
+	if i := strings.Index(version, ":"); i >= 0 {
+		epoch, err := strconv.Atoi(version[:i])
+		if err != nil || epoch < 0 {
+			return v, fmt.Errorf("invalid epoch in version %q", version)
+		}
+		v.epoch = epoch
+		version = version[i+1:]
+	}
+
+	// the revision is everything after the last hyphen
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		v.revision = version[i+1:]
+		version = version[:i]
+	}
+
+	if version == "" {
+		return v, fmt.Errorf("empty upstream version")
+	}
+	if !isDigit(version[0]) {
+		return v, fmt.Errorf("upstream version %q does not start with a digit", version)
+	}
+	v.upstream = version
+
+	return v, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// debOrder gives the sort weight of a character in the non-digit part of a version
+// '~' sorts before everything, even the end of the string, letters sort before
+// the other characters
+func debOrder(c byte) int {
+	if isDigit(c) {
+		return 0
+	} else if isAlpha(c) {
+		return int(c)
+	} else if c == '~' {
+		return -1
+	}
+	return int(c) + 256
+}
+
+// verrevcmp compares upstream versions or revisions the same way dpkg does
+func verrevcmp(a string, b string) int {
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+
+		// compare the non-digit prefix character by character
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := 0, 0
+			if i < len(a) {
+				ac = debOrder(a[i])
+			}
+			if j < len(b) {
+				bc = debOrder(b[j])
+			}
+			if ac != bc {
+				return ac - bc
+			}
+			i++
+			j++
+		}
+
+		// compare the digit part numerically, ignoring leading zeros
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+
+	return 0
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	} else if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+// compareDebVersions returns -1, 0 or 1 if version a is older, equal or newer than version b
+func compareDebVersions(a string, b string) (int, error) {
+	va, err := parseDebVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseDebVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if va.epoch != vb.epoch {
+		return sign(va.epoch - vb.epoch), nil
+	}
+	if c := verrevcmp(va.upstream, vb.upstream); c != 0 {
+		return sign(c), nil
+	}
+	return sign(verrevcmp(va.revision, vb.revision)), nil
+}
+
+// compareUpstreamVersions compares only the upstream part of two versions
+// this is used when one side comes from a release tag which never carries an epoch or a debian revision,
+// so the epoch the packager gave the installed version says nothing about the tag
+func compareUpstreamVersions(a string, b string) (int, error) {
+	va, err := parseDebVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseDebVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return sign(verrevcmp(va.upstream, vb.upstream)), nil
+}
+
+// versionFromTag turns a release tag like v1.2.3 or release-1.2.3 into a debian version
+// return an empty string if the tag does not contain a usable version
+func versionFromTag(tag string) string {
+	tag = strings.TrimSpace(tag)
+	i := strings.IndexFunc(tag, func(r rune) bool {
+		return r >= '0' && r <= '9'
+	})
+	if i < 0 {
+		return ""
+	}
+	version := tag[i:]
+
+	// tags can't carry an epoch, and a pre-release suffix must sort before the release
+	// other hyphens are left alone, 1.2.3-1 is read as upstream 1.2.3 with a revision
+	version = strings.ReplaceAll(version, ":", ".")
+	version = preReleasePattern.ReplaceAllString(version, "~$1")
+
+	if _, err := parseDebVersion(version); err != nil {
+		return ""
+	}
+	return version
+}
+
+func installedVersion(packageName string) (string, error) {
+	// ask dpkg for the version of the installed package
+	out, err := exec.Command("dpkg-query", "-W", "-f=${Version}", packageName).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get installed version of %s: %v", packageName, err)
+	}
+
+	version := strings.TrimSpace(string(out))
+	if version == "" {
+		return "", fmt.Errorf("package %s has no installed version", packageName)
+	}
+	return version, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import "testing"
+
+func TestVerrevcmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		// leading zeros are ignored
+		{"1.01", "1.1", 0},
+		{"1.001", "1.2", -1},
+		// ~ sorts before everything, even the end of the string
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		// letters sort before other characters, the end before letters
+		{"1.0a", "1.0+", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0b", -1},
+		// a digit where the other version has a letter ends the non-digit part, which sorts first
+		{"1.0", "1.a", -1},
+		{"", "", 0},
+		{"", "1", -1},
+	}
+
+	for _, tt := range tests {
+		if got := sign(verrevcmp(tt.a, tt.b)); got != tt.want {
+			t.Errorf("verrevcmp(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := sign(verrevcmp(tt.b, tt.a)); got != -tt.want {
+			t.Errorf("verrevcmp(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareDebVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		// the epoch wins over everything else
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1:1.0-1", "2:0.1-1", -1},
+		// the revision only counts when the upstream versions are equal
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1.1-1", "1.0-9", 1},
+		{"1.0", "1.0-0", 0},
+		// the last hyphen starts the revision
+		{"1.0-beta-2", "1.0-beta-10", -1},
+		{"2.0~rc1-1", "2.0-1", -1},
+		{"1.0+dfsg-1", "1.0-1", 1},
+	}
+
+	for _, tt := range tests {
+		got, err := compareDebVersions(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("compareDebVersions(%q, %q) failed: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("compareDebVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"", "a1.0", "x:1.0", "1:", "-1"} {
+		if _, err := compareDebVersions(invalid, "1.0"); err == nil {
+			t.Errorf("compareDebVersions(%q, \"1.0\") should fail", invalid)
+		}
+	}
+}
+
+func TestVersionFromTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"v1.2.3", "1.2.3"},
+		{"release-1.2.3", "1.2.3"},
+		{"1.2.3", "1.2.3"},
+		// pre-release suffixes sort before the release
+		{"v1.2.3-rc1", "1.2.3~rc1"},
+		{"v1.2.3-beta.2", "1.2.3~beta.2"},
+		{"v1.2.3-alpha", "1.2.3~alpha"},
+		{"v2.0.0-RC1", "2.0.0~RC1"},
+		// other hyphens are kept, the part after the last one is the revision
+		{"v1.2.3-1", "1.2.3-1"},
+		// tags can't carry an epoch
+		{"v1:2", "1.2"},
+		{"latest", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := versionFromTag(tt.tag); got != tt.want {
+			t.Errorf("versionFromTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+
+	// a pre-release tag is older than the release
+	if cmp, err := compareDebVersions(versionFromTag("v1.2.3-rc1"), versionFromTag("v1.2.3")); err != nil || cmp >= 0 {
+		t.Errorf("v1.2.3-rc1 should be older than v1.2.3, got %d, %v", cmp, err)
+	}
+}
@@ -0,0 +1,345 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// doctorTimeout bounds each network check of doctor
+const doctorTimeout = 15 * time.Second
+
+// severity of a doctor finding
+type severity int
+
+const (
+	severityOK severity = iota
+	severityInfo
+	severityWarning
+	severityError
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityOK:
+		return "OK"
+	case severityInfo:
+		return "INFO"
+	case severityWarning:
+		return "WARNING"
+	}
+	return "ERROR"
+}
+
+func (s severity) color() string {
+	switch s {
+	case severityOK:
+		return Green
+	case severityInfo:
+		return Cyan
+	case severityWarning:
+		return Yellow
+	}
+	return Red
+}
+
+// finding is one result of a doctor check
+type finding struct {
+	severity severity
+	message  string
+	// fix repairs the problem, nil if doctor can't repair it safely
+	fix func() error
+}
+
+func checkTools() []finding {
+	// ezdeb installs through apt and sudo and reads dpkg
+	var findings []finding
+	for _, tool := range []string{"dpkg", "dpkg-query", "apt-get", "apt", "sudo"} {
+		if path, err := exec.LookPath(tool); err != nil {
+			findings = append(findings, finding{severity: severityError, message: tool + " not found in PATH"})
+		} else {
+			findings = append(findings, finding{severity: severityOK, message: tool + " found at " + path})
+		}
+	}
+	return findings
+}
+
+func checkLogFile() []finding {
+	// the log file is created by the first logged command, commands fail to log without it
+	dir, err := ezdebDir()
+	if err != nil {
+		return []finding{{severity: severityError, message: err.Error()}}
+	}
+	logFile := filepath.Join(dir, "ezdeb.log")
+	if _, err := os.Stat(logFile); err == nil {
+		return []finding{{severity: severityOK, message: "log file " + logFile + " exists"}}
+	}
+	return []finding{{
+		severity: severityWarning,
+		message:  "log file " + logFile + " is missing",
+		fix: func() error {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		},
+	}}
+}
+
+func checkCatalogFiles() []finding {
+	// every configured catalog should be synced
+	catalogs, err := allCatalogs()
+	if err != nil {
+		return []finding{{severity: severityError, message: "failed to read catalogs: " + err.Error()}}
+	}
+
+	var findings []finding
+	for _, c := range catalogs {
+		filePath, err := catalogPath(c.Name)
+		if err != nil {
+			findings = append(findings, finding{severity: severityError, message: err.Error()})
+			continue
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			findings = append(findings, finding{severity: severityWarning, message: "catalog " + c.Name + " is not synced, run ezdeb sync"})
+			continue
+		}
+		if _, err := validateCatalog(data); err != nil {
+			findings = append(findings, finding{severity: severityError, message: fmt.Sprintf("catalog %s is invalid: %v", c.Name, err)})
+			continue
+		}
+		findings = append(findings, finding{severity: severityOK, message: "catalog " + c.Name + " is synced and valid"})
+	}
+	return findings
+}
+
+func checkPackageStates(installed map[string]string) []finding {
+	// compare the ezdeb state with what dpkg reports and what the catalogs offer
+	state, err := readState()
+	if err != nil {
+		return []finding{{severity: severityError, message: "failed to read state: " + err.Error()}}
+	}
+
+	var findings []finding
+	for _, name := range state.names(false) {
+		name := name
+		record := state.Packages[name]
+
+		version, found := installed[name]
+		if !found {
+			// removed with apt or dpkg, a hold on it went with it
+			message := name + " is managed by ezdeb but not installed, it was removed outside ezdeb"
+			if record.Hold != nil {
+				message += " and is still held"
+			}
+			findings = append(findings, finding{
+				severity: severityWarning,
+				message:  message,
+				fix:      func() error { return removePackageState(name) },
+			})
+			continue
+		}
+
+		if version != record.Version {
+			findings = append(findings, finding{
+				severity: severityWarning,
+				message:  fmt.Sprintf("%s is installed at version %s but ezdeb recorded %s", name, version, record.Version),
+				fix: func() error {
+					return updateState(func(state *ezdebState) error {
+						if record, found := state.Packages[name]; found {
+							record.Version = version
+						}
+						return nil
+					})
+				},
+			})
+		}
+
+		// the catalog entry the package was installed from
+		if _, found := lookupPackage(record.qualifiedEntry()); found {
+			findings = append(findings, finding{severity: severityOK, message: name + " " + record.Version + " from catalog " + record.Catalog})
+			continue
+		}
+		entry, found := lookupCatalogEntry(record.entryName())
+		if !found {
+			findings = append(findings, finding{severity: severityWarning, message: name + " is no longer in any catalog, it can't be updated"})
+			continue
+		}
+		// another catalog still offers it, follow that one
+		catalog := entry.catalog
+		findings = append(findings, finding{
+			severity: severityWarning,
+			message:  fmt.Sprintf("%s is no longer in catalog %q but catalog %q has it", name, record.Catalog, catalog),
+			fix: func() error {
+				return updateState(func(state *ezdebState) error {
+					if record, found := state.Packages[name]; found {
+						record.Catalog = catalog
+					}
+					return nil
+				})
+			},
+		})
+	}
+
+	if len(state.Packages) == 0 {
+		findings = append(findings, finding{severity: severityInfo, message: "no packages managed by ezdeb"})
+	}
+	return findings
+}
+
+func checkNetwork() []finding {
+	// the catalog urls and the sources of the managed packages must be reachable
+	var findings []finding
+
+	catalogs, _ := allCatalogs()
+	for _, c := range catalogs {
+		for _, location := range append([]string{c.URL}, c.Mirrors...) {
+			if !isRemoteLocation(location) {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+			err := checkReachable(ctx, location)
+			cancel()
+			if err != nil {
+				findings = append(findings, finding{severity: severityWarning, message: fmt.Sprintf("catalog %s url %s is not reachable: %v", c.Name, location, err)})
+			} else {
+				findings = append(findings, finding{severity: severityOK, message: "catalog " + c.Name + " url " + location + " is reachable"})
+			}
+		}
+	}
+
+	state, err := readState()
+	if err != nil {
+		return findings
+	}
+	for _, name := range state.names(false) {
+		pkg, found := lookupPackage(state.Packages[name].qualifiedEntry())
+		if !found {
+			continue
+		}
+		src, err := newSource(pkg)
+		if err != nil {
+			findings = append(findings, finding{severity: severityError, message: fmt.Sprintf("%s has an invalid catalog entry: %v", name, err)})
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		_, err = src.Latest(ctx)
+		cancel()
+		if err != nil {
+			findings = append(findings, finding{severity: severityWarning, message: fmt.Sprintf("%s source %s is not reachable: %v", name, src, err)})
+		} else {
+			findings = append(findings, finding{severity: severityOK, message: name + " source " + src.String() + " is reachable"})
+		}
+	}
+	return findings
+}
+
+func checkReachable(ctx context.Context, url string) error {
+	// a HEAD request is enough to know the server answers
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check ezdeb state against the system",
+	Long: `Check ezdeb state against the system and report problems by severity
+Checks dpkg, apt and sudo, the log file, the synced catalogs, packages removed or changed
+outside ezdeb, packages whose catalog entry disappeared and the reachability of the sources.
+--fix repairs what can be repaired safely. Exits with status 1 if errors remain.
+Usage: ezdeb doctor [--fix] [--offline]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fix, _ := cmd.Flags().GetBool("fix")
+		offline, _ := cmd.Flags().GetBool("offline")
+
+		// repairs change the state, hold the lock like any other command that does
+		if fix {
+			release, err := acquireLock(waitForLock(cmd))
+			if err != nil {
+				fmt.Println(Red, err, Reset)
+				os.Exit(1)
+			}
+			defer release()
+		}
+
+		var findings []finding
+		findings = append(findings, checkTools()...)
+		findings = append(findings, checkLogFile()...)
+		findings = append(findings, checkCatalogFiles()...)
+		if installed, err := installedPackages(); err != nil {
+			findings = append(findings, finding{severity: severityError, message: err.Error()})
+		} else {
+			findings = append(findings, checkPackageStates(installed)...)
+		}
+		if !offline {
+			findings = append(findings, checkNetwork()...)
+		}
+
+		counts := make(map[severity]int)
+		fixable := 0
+		for _, f := range findings {
+			if f.severity == severityOK && !verbose {
+				continue
+			}
+			line := fmt.Sprintf("[%s] %s", f.severity, f.message)
+			if f.fix != nil && fix {
+				if err := f.fix(); err != nil {
+					fmt.Println(f.severity.color(), line, "- fix failed:", err, Reset)
+					counts[f.severity]++
+				} else {
+					fmt.Println(Green, line, "- fixed", Reset)
+				}
+				continue
+			}
+			if f.fix != nil {
+				fixable++
+				line += " (fixable)"
+			}
+			fmt.Println(f.severity.color(), line, Reset)
+			counts[f.severity]++
+		}
+
+		fmt.Println()
+		fmt.Println(counts[severityError], "errors,", counts[severityWarning], "warnings,", counts[severityInfo], "notes")
+		if fixable > 0 {
+			fmt.Println(Cyan, "Run ezdeb doctor --fix to repair", fixable, "problems", Reset)
+		}
+		if counts[severityError] > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().Bool("fix", false, "Repair the problems that can be repaired safely")
+	doctorCmd.Flags().Bool("offline", false, "Skip the network checks")
+}
@@ -0,0 +1,45 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the managed packages as a manifest",
+	Long: `Export the packages ezdeb manages, their installed versions and holds and the added catalogs as YAML
+The manifest can be applied on another machine with ezdeb apply.
+--pin also pins the installed versions, apply then installs at least these versions.
+Usage: ezdeb export [--pin] > ezdeb.yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pin, _ := cmd.Flags().GetBool("pin")
+
+		m, err := buildManifest(pin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, Red, "Failed to export:", err, Reset)
+			os.Exit(1)
+		}
+
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(m); err != nil {
+			fmt.Fprintln(os.Stderr, Red, "Failed to export:", err, Reset)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().Bool("pin", false, "Pin the installed versions so apply installs at least these versions")
+}
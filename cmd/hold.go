@@ -6,58 +6,18 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"strings"
-	"path/filepath"
-	"io/ioutil"
 
 	"github.com/spf13/cobra"
 )
 
-var heldPkgNames []string
-
-func listHeldPkgs(dirPath string) {
-	// Create the folder if it doesnt exist
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		err := os.MkdirAll(dirPath, os.ModePerm)
-		if err != nil {
-			fmt.Println(Red, "Failed to create held folder", Reset)
-			return
-		}
-	}
-
-	// List all the package config files in the folder
-	files, err := ioutil.ReadDir(dirPath)
-	if err != nil {
-		fmt.Println(Red, "Failed to read held folder", Reset)
-		return
-	}
-
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-            fileName := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
-            heldPkgNames = append(heldPkgNames, fileName)
-        }
-	}
-}
-
 func isHeldPkg(pkg string) (bool, error) {
-	homeDir, err := os.UserHomeDir()
+	// check if the package is held in the ezdeb state
+	state, err := readState()
 	if err != nil {
-		fmt.Println(Red, "Failed to get user home directory", Reset)
 		return false, err
 	}
-
-	heldDirPath := filepath.Join(homeDir, ".ezdeb", "held")
-
-	listHeldPkgs(heldDirPath)
-
-	for _, heldPkg := range heldPkgNames {
-		if heldPkg == pkg {
-			return true, nil
-		}
-	}
-	return false, nil
+	record, found := state.Packages[pkg]
+	return found && record.Hold != nil, nil
 }
 
 // holdCmd represents the hold command
@@ -79,55 +39,44 @@ Usage: ezdeb hold <package_name>`,
 			return
 		}
 
-		homeDir, err := os.UserHomeDir()
+		state, err := readState()
 		if err != nil {
-			fmt.Println(Red, "Failed to get user home directory", Reset)
+			fmt.Println(Red, "Failed to read installed packages:", err, Reset)
 			return
 		}
-
-		installDirPath := filepath.Join(homeDir, ".ezdeb", "packages")
-		heldDirPath := filepath.Join(homeDir, ".ezdeb", "held")
-
-		err = listPkgConfigs(installDirPath)
-		if err != nil {
-			fmt.Println(Yellow, "Failed to list package configs", Reset)
+		if len(state.Packages) == 0 {
+			fmt.Println(Yellow, "No packages installed", Reset)
 			fmt.Println(Yellow, "Install a package first before holding...", Reset)
 			return
 		}
 
-		listHeldPkgs(heldDirPath)
-
 		for _, pkg := range args {
+			pkg = dpkgName(pkg)
 			// if pkg is not installed skip
 			if !isInstalled(pkg) {
 				fmt.Println(Red, "Package", pkg, "not installed\n", Reset)
 				continue
 			}
-			if check, _ := isHeldPkg(pkg); check {
+			record, found := state.Packages[pkg]
+			if !found {
+				fmt.Println(Red, "Package", pkg, "was not installed with ezdeb\n", Reset)
+				continue
+			}
+			if record.Hold != nil {
 				fmt.Println(Red, "Package", pkg, "already held\n", Reset)
 				continue
-			} else {
-				// if it is not held then check if config file exists in packages folder
-				for _, check2 := range pkgNames {
-					// if it exists in packages folder then create a file in held folder
-					if pkg == check2 {
-						fileName := filepath.Join(heldDirPath, pkg+".json")
-						_, err := os.Create(fileName)
-						if err != nil {
-							fmt.Println(Red, "Failed to create held file", Reset)
-							continue
-						} else {
-							fmt.Println(Green, "Package", pkg, "held\n", Reset)
-							logger.Infof("hold: %v", pkg)
-							continue
-						}
-					}
-				}
 			}
+			if err := setHold([]string{pkg}, true); err != nil {
+				fmt.Println(Red, "Failed to hold package", pkg+":", err, Reset)
+				continue
+			}
+			fmt.Println(Green, "Package", pkg, "held\n", Reset)
+			logger.Infof("hold: %v", pkg)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(holdCmd)
+	withLock(holdCmd)
 }
@@ -6,9 +6,10 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // infoCmd represents the info command
@@ -16,6 +17,7 @@ var infoCmd = &cobra.Command{
 	Use:   "info",
 	Short: "Show information about a particular package",
 	Long: `Show information about a particular package
+Use catalog/package to show the entry of a particular catalog.
 Usage: ezdeb info <package_name>`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
@@ -30,34 +32,61 @@ Usage: ezdeb info <package_name>`,
 
 		pkgName := args[0]
 
-		packages := viper.Get("packages").([]interface{})
-		for _, pkg := range packages {
-			pkgMap := pkg.(map[string]interface{})
-			if pkgMap["name"].(string) == pkgName {
-				fmt.Println("Package name: ", pkgMap["name"])
-				fmt.Println("Package description: ", pkgMap["description"])
-				fmt.Println("Package source: ", pkgMap["source"])
-				if pkgMap["source"] == "github" {
-					fmt.Println("Package Repository: ", pkgMap["ghuser"], "/", pkgMap["ghrepo"])
-				}
-				if pkgMap["source"] == "website" {
-					fmt.Println("Package link: ", pkgMap["link"])
-				}
-				if isInstalled(pkgName) {
-					fmt.Println("Installed: Yes")
-				} else {
-					fmt.Println("Installed: No")
-				}
-				if held, err := isHeldPkg(pkgName); err == nil && held {
-					fmt.Println("Held: Yes")
-				} else {
-					fmt.Println("Held: No")
-				}
-				return
-			}
+		entry, found := lookupCatalogEntry(pkgName)
+		if !found {
+			fmt.Println("Package not found")
+			return
 		}
 
-		fmt.Println("Package not found")
+		pkg := entry.pkg
+		// dpkg and the state know the package by the name the entry installs
+		pkgName = pkg.debName()
+		fmt.Println("Package name: ", pkg.Name)
+		if pkgName != pkg.Name {
+			fmt.Println("Installs as: ", pkgName)
+		}
+		fmt.Println("Package description: ", pkg.Description)
+		fmt.Println("Package catalog: ", entry.catalog)
+		fmt.Println("Package source: ", pkg.Source)
+		if src, err := newSource(pkg); err == nil {
+			fmt.Println("Package origin: ", src)
+		}
+		if isInstalled(pkgName) {
+			fmt.Println("Installed: Yes")
+		} else {
+			fmt.Println("Installed: No")
+		}
+		if record, err := readPackageState(pkgName); err == nil {
+			fmt.Println("Installed version: ", record.Version)
+			if record.Tag != "" {
+				fmt.Println("Installed from tag: ", record.Tag)
+			}
+			if record.AssetURL != "" {
+				fmt.Println("Installed from: ", record.AssetURL)
+			}
+			fmt.Println("Installed at: ", record.InstalledAt.Local().Format(time.RFC1123))
+			if record.Adopted {
+				fmt.Println("Adopted: Yes")
+			}
+			if record.SignedBy != "" {
+				fmt.Println("Signed by: ", record.SignedBy)
+			}
+		}
+		if held, err := isHeldPkg(pkgName); err == nil && held {
+			fmt.Println("Held: Yes")
+		} else {
+			fmt.Println("Held: No")
+		}
+		if len(entry.overlaid) > 0 {
+			fmt.Println("Overlay fields: ", strings.Join(entry.overlaid, ", "))
+		}
+		if entry.catalog == overlayCatalog {
+			fmt.Println("Catalog signed by: ", "local overlay")
+		} else if signedBy, err := catalogSigner(entry.catalog); err == nil {
+			fmt.Println("Catalog signed by: ", signedBy)
+		} else {
+			fmt.Println("Catalog signed by: ", err)
+		}
 	},
 }
 
@@ -7,28 +7,14 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"strings"
 	"path/filepath"
-	"regexp"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"github.com/google/go-github/v50/github"
-	"github.com/schollz/progressbar/v3"
 )
 
-// Create variables for global usage
-var ghuser string
-var ghrepo string
-var pkgurl string
-var debName string
-var debSize int64
-
 func isInstalled(packageName string) bool {
 	// check if the package is installed in the system
 	// return true if installed
@@ -42,194 +28,92 @@ func isInstalled(packageName string) bool {
 		return false
 }
 
-func searchPkgDetails(pkgName string) bool {
-	// search for the package in the config file
-	// if found return true and set the global variables
-	// return false if not found
-	packages := viper.Get("packages").([]interface{})
-	for _, pkg := range packages {
-		pkgMap := pkg.(map[string]interface{})
-		if pkgMap["name"].(string) == pkgName {
-			if pkgMap["source"].(string) == "github" {
-				ghuser = pkgMap["ghuser"].(string)
-				ghrepo = pkgMap["ghrepo"].(string)
-				pkgurl = ""
-				return true
-			} else if pkgMap["source"].(string) == "website" {
-				pkgurl = pkgMap["url"].(string)
-				ghuser, ghrepo = "", ""
-				return true
-			}
-		}
-	}
-	return false
+// fetchedDeb is a downloaded .deb that passed verification
+type fetchedDeb struct {
+	location string
+	info     *debInfo
+	// sha256 is the checksum of the downloaded file
+	sha256 string
+	// checksumFrom says where the expected checksum came from, empty if there was none
+	checksumFrom string
+	// signedBy names the keys that signed the file, empty if the catalog declares none
+	signedBy string
 }
 
-func fetchGithubRelease(ghuser string, ghrepo string) (string, error) {
-	// download the latest .deb file from github release
-	client := github.NewClient(nil)
-	ctx := context.Background()
-
-	// get latest release
-	ghRelease, _, err := client.Repositories.GetLatestRelease(ctx, ghuser, ghrepo)
-	if err != nil {
-		return "", fmt.Errorf("failed to get latest release: %v", err)
-	}
-
-	// find deb file asset
-	var asset *github.ReleaseAsset
+func fetchRelease(ctx context.Context, pkg *Package, src Source, rel Release) (*fetchedDeb, error) {
+	// download the .deb of a release into os.TempDir()/ezdeb
+	// and check it against its checksum and the catalog entry
 
-	// first search for .deb file with amd64 or x86_64 in name to avoid arm builds
-	for _, a := range ghRelease.Assets {
-		if filepath.Ext(a.GetName()) == ".deb" && (strings.Contains(a.GetName(), "amd64") || strings.Contains(a.GetName(), "x86_64")) {
-			asset = a
-			debName = a.GetName()
-			break
+	// Create os.TempDir()/ezdeb if it doesn't exist
+	tempDir := filepath.Join(os.TempDir(), "ezdeb")
+	if _, err := os.Stat(tempDir); os.IsNotExist(err) {
+		err = os.Mkdir(tempDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary directory: %v", err)
 		}
 	}
 
-	// if no .deb file was found with arch in name then search for .deb files
-	if asset == nil {
-		for _, a := range ghRelease.Assets {
-			if filepath.Ext(a.GetName()) == ".deb" {
-				asset = a
-				debName = a.GetName()
-				break
-			}
+	// a checksum pinned in the catalog wins over one published with the release
+	expected, from := pkg.SHA256, "catalog"
+	if expected == "" {
+		var err error
+		if expected, from, err = findChecksum(ctx, src, rel); err != nil {
+			return nil, err
 		}
 	}
-
-	// if no .deb file was found then return error
-	if asset == nil {
-		return "", fmt.Errorf("no .deb file asset found in release")
+	if expected == "" && requireChecksum {
+		return nil, fmt.Errorf("no checksum found for %s and --require-checksum is set", rel.Asset.Name)
 	}
 
-	// download deb file
-	resp, err := http.Get(asset.GetBrowserDownloadURL())
-	if err != nil {
-		return "", fmt.Errorf("failed to get download link: %v", err)
+	debFileLoc := filepath.Join(tempDir, filepath.Base(rel.Asset.Name))
+	if err := src.Download(ctx, rel, debFileLoc); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// create progress bar and set it to the number of bytes downloaded
-	bar := progressbar.DefaultBytes(
-		resp.ContentLength,
-		"downloading",
-	)
-
-	// Create os.TempDir()/ezdeb if it doesn't exist
-	if _, err := os.Stat(filepath.Join(os.TempDir(), "ezdeb")); os.IsNotExist(err) {
-		err = os.Mkdir(filepath.Join(os.TempDir(), "ezdeb"), 0755)
-		if err != nil {
-			return "", fmt.Errorf("failed to create temporary directory: %v", err)
-		}
+	sum, err := verifyChecksum(debFileLoc, rel.Asset.Name, expected)
+	if err != nil {
+		os.Remove(debFileLoc)
+		return nil, err
 	}
 
-	// create temporary file
-	debFileLoc := filepath.Join(os.TempDir(), "ezdeb", asset.GetName())
-	f, err := os.Create(debFileLoc)
+	// make sure the download is the package the catalog promised
+	info, err := verifyDebPackage(debFileLoc, pkg.debName())
 	if err != nil {
-		return "", fmt.Errorf("failed to create temporary file: %v", err)
+		os.Remove(debFileLoc)
+		return nil, err
 	}
-	defer f.Close()
 
-	// write content to file
-	_, err = io.Copy(io.MultiWriter(f, bar), resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to write to temporary file: %v", err)
+	if verbose && expected != "" {
+		fmt.Println(Cyan, "Checksum of", rel.Asset.Name, "verified against", from, Reset)
 	}
 
-	// grab the downloaded file size
-	fileInfo, err := f.Stat()
+	// refuse the package if the catalog declares a signing key and the signature doesn't verify
+	signedBy, err := verifySignature(ctx, pkg, src, rel, debFileLoc)
 	if err != nil {
-		return "", fmt.Errorf("failed to get file info: %v", err)
+		os.Remove(debFileLoc)
+		return nil, err
 	}
-	debSize = fileInfo.Size()
-
-	// Return the location of the downloaded file
-	return debFileLoc, nil
 
+	return &fetchedDeb{location: debFileLoc, info: info, sha256: sum, checksumFrom: from, signedBy: signedBy}, nil
 }
 
-func fetchPackage(url string) (string, error) {
-	// download the package from the url
-
-	// if the url is a dynamic url i.e it keeps changing the .deb name then
-	// we need to search for the package in the page and get the url of the .deb file
-	if !strings.Contains(url, ".deb") {
-		pageResp, err := http.Get(url)
-		if err != nil {
-			return "", fmt.Errorf("failed to get download link: %v", err)
-		}
-		defer pageResp.Body.Close()
-
-		// Read the response body into a buffer
-		body, err := ioutil.ReadAll(pageResp.Body)
-		if err != nil {
-			return "", fmt.Errorf("failed to get download link: %v", err)
-		}
-
-		// Find the URL of the .deb package
-		re := regexp.MustCompile(`"([^"]*\.deb)"`)
-		matches := re.FindSubmatch(body)
-		if len(matches) < 2 {
-			return "", fmt.Errorf("No .deb package found in response body")
-		}
-
-		// Construct the download URL of the .deb package
-		url := fmt.Sprintf("%s/%s", url, matches[1])
-		urlParts := strings.Split(url, "/")
-		debName = urlParts[len(urlParts)-1]
-	} else {
-		// if the url is a .deb file then we just need to grab the name of the .deb file
-		urlParts := strings.Split(url, "/")
-		debName = urlParts[len(urlParts)-1]
-	}
-
-    // Download the .deb package
-    resp, err := http.Get(url)
-    if err != nil {
-        return "", fmt.Errorf("failed to download package: %v", err)
-    }
-    defer resp.Body.Close()
-
-	// create progress bar and set it to the number of bytes downloaded
-	bar := progressbar.DefaultBytes(
-		resp.ContentLength,
-		"downloading",
-	)
-
-	// Create os.TempDir()/ezdeb if it doesn't exist
-	if _, err := os.Stat(filepath.Join(os.TempDir(), "ezdeb")); os.IsNotExist(err) {
-		err = os.Mkdir(filepath.Join(os.TempDir(), "ezdeb"), 0755)
-		if err != nil {
-			return "", fmt.Errorf("failed to create temporary directory: %v", err)
-		}
+func fetchEntry(ctx context.Context, entry catalogEntry) (Release, *fetchedDeb, error) {
+	// resolve the latest release of a catalog entry and download its .deb
+	src, err := newSource(entry.pkg)
+	if err != nil {
+		return Release{}, nil, fmt.Errorf("invalid package details: %v", err)
 	}
 
-    // Create a temporary file to store the downloaded .deb package
-    debFileLoc := filepath.Join(os.TempDir(), "ezdeb", debName)
-	f, err := os.Create(debFileLoc)
+	rel, err := src.Latest(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temporary file: %v", err)
+		return Release{}, nil, err
 	}
-	defer f.Close()
 
-    // Copy the contents of the downloaded .deb package to the temporary file
-    _, err = io.Copy(io.MultiWriter(f, bar), resp.Body)
-    if err != nil {
-        return "", fmt.Errorf("failed to write to temporary file: %v", err)
-    }
-
-	// grab the downloaded file size
-	fileInfo, err := f.Stat()
+	deb, err := fetchRelease(ctx, entry.pkg, src, rel)
 	if err != nil {
-		return "", fmt.Errorf("failed to get file info: %v", err)
+		return Release{}, nil, err
 	}
-	debSize = fileInfo.Size()
-
-    // Return the location of the downloaded file
-    return debFileLoc, nil
+	return rel, deb, nil
 }
 
 func installPackage(location string) error {
@@ -246,38 +130,15 @@ func installPackage(location string) error {
     return nil
 }
 
-func storePackageDetails(packageName string, packageVersion string) error {
-	// Store the package name and version in the package.json file
-	// Get the home directory of the user
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-
-	dirPath := filepath.Join(homeDir, ".ezdeb", "packages")
-
-	// Create the directory if it does not exist
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		err := os.MkdirAll(dirPath, os.ModePerm)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Create the package config file
-	filePath := filepath.Join(dirPath, packageName+".json")
-
-	// insert info into the package.json file
-	pkgConfig := viper.New()
-	pkgConfig.Set("name", packageName)
-	pkgConfig.Set("version", packageVersion)
-	pkgConfig.Set("size", debSize)
-	err = pkgConfig.WriteConfigAs(filePath)
-	if err != nil {
-		return err
-	}
-
-	return nil
+func storePackageDetails(catalog string, pkg *Package, rel Release, deb *fetchedDeb) error {
+	// record the installed version, where it came from and its checksum and signer in the ezdeb state
+	record := packageRecord(catalog, pkg)
+	record.Version = deb.info.Version
+	record.Tag = rel.Tag
+	record.AssetURL = rel.Asset.URL
+	record.SHA256 = deb.sha256
+	record.SignedBy = deb.signedBy
+	return storePackageState(record)
 }
 
 // installCmd represents the install command
@@ -285,7 +146,9 @@ var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Install a package",
 	Long: `Install a package
-Usage: ezdeb install <package_name>`,
+Use catalog/package to install from a particular catalog.
+Use --locked to install exactly the artifacts recorded by ezdeb lock.
+Usage: ezdeb install <package_name> [--locked [--lockfile ezdeb.lock]]`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		/*
@@ -293,8 +156,8 @@ Usage: ezdeb install <package_name>`,
 		Ensure argument is provided
 		check if package is already installed
 		check if package exists in the list and fetch details
-		check if source is gh or url
-		download gh release or url file
+		resolve the latest release from the package source
+		download the release .deb and check it is the expected package
 		install package with apt
 		on successful installation store pkg details, separate file for every pkg
 		show success or error msg
@@ -313,61 +176,77 @@ Usage: ezdeb install <package_name>`,
 			return
 		}
 
+		// --locked installs the artifacts recorded by ezdeb lock instead of the latest releases
+		var lf *lockfile
+		if locked, _ := cmd.Flags().GetBool("locked"); locked {
+			if lf, err = readLockfile(cmd.Flag("lockfile").Value.String()); err != nil {
+				fmt.Println(Red, err, Reset)
+				return
+			}
+		}
+
+		ctx := context.Background()
+
 		for _, pkg := range args {
 
 			fmt.Println(Yellow, "\n\nInstalling package ", pkg, Reset)
 
-			if isInstalled(pkg) {
+			// pkg may name the catalog as catalog/package
+			var entry catalogEntry
+			var locked lockedPackage
+			if lf != nil {
+				if entry, locked, err = lockedEntry(lf, pkg); err != nil {
+					fmt.Println(Red, "\n\nFailed to fetch package ", pkg, ":", err, Reset)
+					continue
+				}
+			} else {
+				var found bool
+				if entry, found = lookupCatalogEntry(pkg); !found {
+					fmt.Println(Red, "\n\nPackage ", pkg, "not found", Reset)
+					continue
+				}
+			}
+
+			// the entry may install a package named differently from the entry
+			if isInstalled(entry.pkg.debName()) {
 				fmt.Println(Green, "\n\nPackage ", pkg, " is already installed", Reset)
 				continue
 			}
 
-			if !searchPkgDetails(pkg) {
-				fmt.Println(Red, "\n\nPackage ", pkg, "not found", Reset)
+			var rel Release
+			var deb *fetchedDeb
+			if lf != nil {
+				rel, deb, err = fetchLocked(ctx, entry, locked)
+			} else {
+				rel, deb, err = fetchEntry(ctx, entry)
+			}
+			if err != nil {
+				fmt.Println(Red, "\n\nFailed to fetch package ", pkg, ":", err, Reset)
 				continue
 			}
 
-			if ghuser != "" && ghrepo != "" {
-				if location, err := fetchGithubRelease(ghuser, ghrepo); err == nil {
-					if err = installPackage(location); err == nil {
-						if err = storePackageDetails(pkg, debName); err == nil {
-							logger.Infof("install: %v", pkg)
-							fmt.Println(Green, "\n\nPackage ", pkg, " installed successfully\n", Reset)
-						} else {
-							fmt.Println(Yellow, "\n\nPackage ", pkg, " successfully installed but not logged", Reset)
-							continue
-						}
-					} else {
-						fmt.Println(Red, "\n\nFailed to install package ", pkg, Reset)
-						continue
-					}
-				} else {
-					fmt.Println(Red, "\n\nFailed to fetch package ", pkg, Reset)
-					continue
-				}
-			} else if pkgurl != "" {
-				if location, err := fetchPackage(pkgurl); err == nil {
-					if err = installPackage(location); err == nil {
-						if err = storePackageDetails(pkg, debName); err == nil {
-							logger.Infof("install: %v", pkg)
-							fmt.Println(Green, "\n\nPackage ", pkg, " installed successfully", Reset)
-						} else {
-							fmt.Println(Green, "\n\nPackage ", pkg, " successfully installed but not logged", Reset)
-							continue
-						}
-					} else {
-						fmt.Println(Red, "\n\nFailed to install package ", pkg, Reset)
-						continue
-					}
-				} else {
-					fmt.Println(Red, "\n\nFailed to fetch package ", pkg, Reset)
-					continue
-				}
+			if err = installPackage(deb.location); err != nil {
+				fmt.Println(Red, "\n\nFailed to install package ", pkg, Reset)
+				continue
 			}
+
+			if err = storePackageDetails(entry.catalog, entry.pkg, rel, deb); err != nil {
+				fmt.Println(Yellow, "\n\nPackage ", pkg, " successfully installed but not logged", Reset)
+				continue
+			}
+
+			logger.Infof("install: %v", pkg)
+			fmt.Println(Green, "\n\nPackage ", pkg, " installed successfully\n", Reset)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(installCmd)
+	withLock(installCmd)
+
+	installCmd.Flags().BoolVar(&requireChecksum, "require-checksum", false, "Refuse packages without a known SHA256 checksum")
+	installCmd.Flags().BoolVar(&allowUnsignedRepo, "allow-unsigned-repo", false, "Accept apt repositories without a gpg key in the catalog")
+	installCmd.Flags().Bool("locked", false, "Install exactly the artifacts recorded in the lockfile")
+	installCmd.Flags().String("lockfile", defaultLockfile, "Lockfile used by --locked")
 }
@@ -6,14 +6,20 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
+func printCatalogEntry(entry catalogEntry) {
+	// packages from an added catalog are shown as catalog/package
+	name := entry.name()
+	if entry.catalog != mainCatalog {
+		name = entry.qualifiedName()
+	}
+	fmt.Println(Cyan, name, Reset, " - ", entry.pkg.Description)
+	fmt.Println()
+}
+
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list",
@@ -26,31 +32,16 @@ Usage: ezdeb list`,
 
 		// List only installed packages if flag is set
 		if cmd.Flag("installed").Value.String() == "true" {
-			fmt.Println("Listing installed packages\n")
+			fmt.Print("Listing installed packages\n\n")
 
-			homeDir, err := os.UserHomeDir()
+			installed, err := managedPackages()
 			if err != nil {
-				fmt.Println(Red, "Error: failed to get user home directory", Reset)
+				fmt.Println(Red, "Error: failed to list packages:", err, Reset)
 				return
 			}
-			pkgPath := filepath.Join(homeDir, ".ezdeb", "packages")
-
-			err = filepath.Walk(pkgPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					fmt.Println(Red, "Error: failed to access path", Reset)
-					return err
-				}
-				if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
-					// trim .json suffix from file name
-					fileName := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
-					fmt.Println(Cyan, fileName, Reset)
-					count++
-				}
-				return nil
-			})
-			if err != nil {
-				fmt.Print(Red, "Error: failed to list packages", Reset)
-				return
+			for _, pkg := range installed {
+				fmt.Println(Cyan, pkg, Reset)
+				count++
 			}
 
 			if count == 0 {
@@ -63,18 +54,14 @@ Usage: ezdeb list`,
 
 		// List only held packages if flag is set
 		if cmd.Flag("held").Value.String() == "true" {
-			fmt.Println("Listing held packages\n")
+			fmt.Print("Listing held packages\n\n")
 
-			homeDir, err := os.UserHomeDir()
+			heldPkgNames, err := heldPackages()
 			if err != nil {
-				fmt.Println(Red, "Failed to get user home directory", Reset)
+				fmt.Println(Red, "Failed to read held packages:", err, Reset)
 				return
 			}
 
-			heldDirPath := filepath.Join(homeDir, ".ezdeb", "held")
-
-			listHeldPkgs(heldDirPath)
-
 			if len(heldPkgNames) == 0 {
 				fmt.Println("No held packages")
 				return
@@ -89,13 +76,10 @@ Usage: ezdeb list`,
 
 		}
 
-		fmt.Println("Available packages:\n")
-
-		packages := viper.Get("packages").([]interface{})
-		for _, pkg := range packages {
-			pkgMap := pkg.(map[string]interface{})
+		fmt.Print("Available packages:\n\n")
 
-			fmt.Println(Cyan, pkgMap["name"], Reset, " - ", pkgMap["description"], "\n")
+		for _, entry := range catalogPackages() {
+			printCatalogEntry(entry)
 			count++
 		}
 
@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// lockHeld is true while this process holds the ezdeb lock
+var lockHeld bool
+
+func lockHolder(f *os.File) string {
+	// name the process in a lock file, "another ezdeb" if it can't be read
+	data := make([]byte, 32)
+	n, _ := f.ReadAt(data, 0)
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n]))); err == nil {
+		return fmt.Sprintf("ezdeb (PID %d)", pid)
+	}
+	return "another ezdeb"
+}
+
+func acquireLock(wait bool) (func(), error) {
+	// take the ezdeb lock so only one command changes packages, state and catalogs at a time
+	// the lock is released by the returned func, or by the kernel when the process exits
+	dir, err := ezdebDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "lock")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		holder := lockHolder(f)
+		if !wait {
+			f.Close()
+			return nil, fmt.Errorf("%s is already running, retry when it is done or use --wait", holder)
+		}
+		fmt.Println(Yellow, "Waiting for", holder, "to finish...", Reset)
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+	}
+
+	// record our PID so a second ezdeb can name us
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	lockHeld = true
+
+	return func() {
+		lockHeld = false
+		f.Truncate(0)
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func waitForLock(cmd *cobra.Command) bool {
+	// --no-wait wins over --wait, EZDEB_WAIT and the wait setting
+	if noWait, _ := cmd.Flags().GetBool("no-wait"); noWait {
+		return false
+	}
+	return settings.GetBool("wait")
+}
+
+func withLock(cmd *cobra.Command) {
+	// run cmd while holding the ezdeb lock
+	run := cmd.Run
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		release, err := acquireLock(waitForLock(cmd))
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+		defer release()
+
+		run(cmd, args)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// lockfileVersion is the newest lockfile format this ezdeb understands
+const lockfileVersion = 1
+
+// defaultLockfile is the lockfile ezdeb lock writes and --locked reads
+const defaultLockfile = "ezdeb.lock"
+
+// lockfile records the exact artifact of every managed package
+type lockfile struct {
+	Version  int             `json:"version"`
+	Packages []lockedPackage `json:"packages"`
+}
+
+// lockedPackage is the artifact a package was installed from
+type lockedPackage struct {
+	Name     string `json:"name"`
+	Catalog  string `json:"catalog"`
+	Version  string `json:"version"`
+	Tag      string `json:"tag,omitempty"`
+	AssetURL string `json:"asset_url"`
+	SHA256   string `json:"sha256"`
+}
+
+func (l *lockfile) find(pkgName string) (lockedPackage, bool) {
+	// a plain name matches the package of any catalog, catalog/package only the one of that catalog
+	catalog, name := splitPackageName(pkgName)
+	for _, p := range l.Packages {
+		if p.Name == name && (catalog == "" || p.Catalog == catalog) {
+			return p, true
+		}
+	}
+	return lockedPackage{}, false
+}
+
+func readLockfile(path string) (*lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile: %v", err)
+	}
+
+	l := &lockfile{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if l.Version > lockfileVersion {
+		return nil, fmt.Errorf("%s has version %d, this ezdeb understands up to %d, update ezdeb", path, l.Version, lockfileVersion)
+	}
+	for _, p := range l.Packages {
+		if p.Name == "" || p.AssetURL == "" || !sha256Pattern.MatchString(p.SHA256) {
+			return nil, fmt.Errorf("%s has an incomplete entry for %q", path, p.Name)
+		}
+	}
+	return l, nil
+}
+
+func lockPackage(ctx context.Context, record *pkgState) (lockedPackage, error) {
+	// the artifact a managed package was installed from
+	// packages adopted or installed by older ezdeb versions have no recorded artifact,
+	// it is resolved from the source and recorded if it is still the installed version
+	installed, err := installedVersion(record.Name)
+	if err != nil {
+		return lockedPackage{}, err
+	}
+	locked := lockedPackage{
+		Name:     record.entryName(),
+		Catalog:  record.Catalog,
+		Version:  installed,
+		Tag:      record.Tag,
+		AssetURL: record.AssetURL,
+		SHA256:   record.SHA256,
+	}
+	// a recorded artifact only counts if dpkg still has the version it was installed at
+	if locked.AssetURL != "" && locked.SHA256 != "" && record.Version == installed {
+		return locked, nil
+	}
+
+	entry, found := lookupCatalogEntry(record.qualifiedEntry())
+	if !found {
+		return locked, fmt.Errorf("no recorded artifact and not found in the catalog")
+	}
+	rel, deb, err := fetchEntry(ctx, entry)
+	if err != nil {
+		return locked, err
+	}
+	// the download was only needed for its checksum
+	os.Remove(deb.location)
+	cmp, err := compareDebVersions(deb.info.Version, installed)
+	if err != nil {
+		return locked, fmt.Errorf("failed to compare installed version %s with the latest release %s: %v", installed, deb.info.Version, err)
+	}
+	if cmp != 0 {
+		return locked, fmt.Errorf("no recorded artifact for installed version %s and the latest release is %s, update it first", installed, deb.info.Version)
+	}
+
+	locked.Tag = rel.Tag
+	locked.AssetURL = rel.Asset.URL
+	locked.SHA256 = deb.sha256
+	err = updateState(func(state *ezdebState) error {
+		if record, found := state.Packages[record.Name]; found {
+			record.Version = locked.Version
+			record.Tag = locked.Tag
+			record.AssetURL = locked.AssetURL
+			record.SHA256 = locked.SHA256
+		}
+		return nil
+	})
+	return locked, err
+}
+
+func fetchLocked(ctx context.Context, entry catalogEntry, locked lockedPackage) (Release, *fetchedDeb, error) {
+	// download exactly the locked artifact of a package
+	// the locked checksum wins over the catalog and the release, a changed or missing artifact fails
+	src, err := newSource(entry.pkg)
+	if err != nil {
+		return Release{}, nil, fmt.Errorf("invalid package details: %v", err)
+	}
+
+	asset := Asset{Name: path.Base(strings.SplitN(locked.AssetURL, "?", 2)[0]), URL: locked.AssetURL}
+	rel := Release{Tag: locked.Tag, SHA256: locked.SHA256, Asset: asset, Assets: []Asset{asset}}
+	pkg := *entry.pkg
+	pkg.SHA256 = locked.SHA256
+
+	deb, err := fetchRelease(ctx, &pkg, src, rel)
+	if err != nil {
+		return Release{}, nil, fmt.Errorf("locked artifact %s changed or disappeared upstream: %v", locked.AssetURL, err)
+	}
+	if deb.info.Version != locked.Version {
+		return Release{}, nil, fmt.Errorf("locked artifact %s contains version %s, expected %s", locked.AssetURL, deb.info.Version, locked.Version)
+	}
+	return rel, deb, nil
+}
+
+func lockedEntry(l *lockfile, pkgName string) (catalogEntry, lockedPackage, error) {
+	// the locked artifact of a package and the catalog entry it was locked from
+	// pkgName may name the catalog as catalog/package
+	locked, found := l.find(pkgName)
+	if !found {
+		return catalogEntry{}, locked, fmt.Errorf("package %s is not in the lockfile", pkgName)
+	}
+	qualified := locked.Name
+	if locked.Catalog != "" {
+		qualified = locked.Catalog + "/" + locked.Name
+	}
+	entry, found := lookupCatalogEntry(qualified)
+	if !found {
+		return catalogEntry{}, locked, fmt.Errorf("package %s not found in the catalog", qualified)
+	}
+	return entry, locked, nil
+}
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Write a lockfile of the installed artifacts",
+	Long: `Write a lockfile with the release tag, asset url and SHA256 of every managed package
+install --locked and apply --locked install exactly these artifacts on another machine.
+Usage: ezdeb lock [-o ezdeb.lock]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output := cmd.Flag("output").Value.String()
+
+		state, err := readState()
+		if err != nil {
+			fmt.Println(Red, "Failed to read installed packages:", err, Reset)
+			os.Exit(1)
+		}
+
+		ctx := context.Background()
+		l := &lockfile{Version: lockfileVersion}
+		failed := 0
+		for _, name := range state.names(false) {
+			locked, err := lockPackage(ctx, state.Packages[name])
+			if err != nil {
+				failed++
+				fmt.Println(Red, "Failed to lock", name+":", err, Reset)
+				continue
+			}
+			l.Packages = append(l.Packages, locked)
+		}
+		if failed > 0 {
+			fmt.Println(Red, failed, "packages could not be locked,", output, "was not written", Reset)
+			os.Exit(1)
+		}
+
+		data, err := json.MarshalIndent(l, "", "  ")
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			os.Exit(1)
+		}
+		if err := writeFileAtomic(output, append(data, '\n'), 0644); err != nil {
+			fmt.Println(Red, "Failed to write", output+":", err, Reset)
+			os.Exit(1)
+		}
+		fmt.Println(Green, "Locked", len(l.Packages), "packages in", output, Reset)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	withLock(lockCmd)
+
+	lockCmd.Flags().StringP("output", "o", defaultLockfile, "Lockfile to write")
+}
@@ -0,0 +1,154 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestVersion is the newest manifest format this ezdeb understands
+const manifestVersion = 1
+
+// manifest is the desired state of a machine as written by export and read by apply
+type manifest struct {
+	Version  int               `yaml:"version"`
+	Catalogs []manifestCatalog `yaml:"catalogs,omitempty"`
+	Packages []manifestPackage `yaml:"packages"`
+}
+
+// manifestCatalog is a catalog added with catalog add, main is configured in config.json instead
+type manifestCatalog struct {
+	Name     string   `yaml:"name"`
+	URL      string   `yaml:"url"`
+	Mirrors  []string `yaml:"mirrors,omitempty"`
+	Priority int      `yaml:"priority,omitempty"`
+	Key      string   `yaml:"key,omitempty"`
+}
+
+// manifestPackage is a package ezdeb manages
+type manifestPackage struct {
+	Name    string `yaml:"name"`
+	Catalog string `yaml:"catalog,omitempty"`
+	// Version is the Debian version installed when the manifest was exported
+	Version string `yaml:"version,omitempty"`
+	// Pin is the lowest Debian version apply accepts, empty follows the latest release
+	Pin  string `yaml:"pin,omitempty"`
+	Hold bool   `yaml:"hold,omitempty"`
+}
+
+func (p manifestPackage) qualifiedName() string {
+	if p.Catalog == "" {
+		return p.Name
+	}
+	return p.Catalog + "/" + p.Name
+}
+
+func buildManifest(pinned bool) (*manifest, error) {
+	// describe the packages ezdeb manages and the catalogs they come from
+	// every package records the version dpkg has installed, pinned also pins it to that version
+	m := &manifest{Version: manifestVersion}
+
+	catalogs, err := readCatalogConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range catalogs {
+		m.Catalogs = append(m.Catalogs, manifestCatalog{
+			Name:     c.Name,
+			URL:      c.URL,
+			Mirrors:  c.Mirrors,
+			Priority: c.Priority,
+			Key:      c.Key,
+		})
+	}
+
+	state, err := readState()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := installedPackages()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range state.names(false) {
+		record := state.Packages[name]
+		p := manifestPackage{Name: record.entryName(), Version: installed[name], Hold: record.Hold != nil}
+		if record.Catalog != mainCatalog {
+			p.Catalog = record.Catalog
+		}
+		if pinned {
+			p.Pin = p.Version
+		}
+		m.Packages = append(m.Packages, p)
+	}
+
+	return m, nil
+}
+
+func readManifest(path string) (*manifest, error) {
+	// read and check a manifest, - reads standard input
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	m := &manifest{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf("%s has version %d, this ezdeb understands up to %d, update ezdeb", path, m.Version, manifestVersion)
+	}
+
+	var errs catalogErrors
+	catalogs := map[string]bool{mainCatalog: true, overlayCatalog: true}
+	for _, c := range m.Catalogs {
+		switch {
+		case !catalogNamePattern.MatchString(c.Name) || c.Name == mainCatalog || c.Name == overlayCatalog:
+			errs = append(errs, fmt.Errorf("invalid catalog name %q", c.Name))
+		case catalogs[c.Name]:
+			errs = append(errs, fmt.Errorf("catalog %s is listed twice", c.Name))
+		case c.URL == "":
+			errs = append(errs, fmt.Errorf("catalog %s has no url", c.Name))
+		}
+		catalogs[c.Name] = true
+	}
+
+	configured, err := readCatalogConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range configured {
+		catalogs[c.Name] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range m.Packages {
+		switch {
+		case p.Name == "":
+			errs = append(errs, fmt.Errorf("package without a name"))
+		case seen[p.Name]:
+			errs = append(errs, fmt.Errorf("package %s is listed twice", p.Name))
+		case p.Catalog != "" && !catalogs[p.Catalog]:
+			errs = append(errs, fmt.Errorf("package %s uses catalog %s, which is neither listed nor configured", p.Name, p.Catalog))
+		}
+		seen[p.Name] = true
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, errs)
+	}
+	return m, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// overlayCatalog is the catalog name of packages that only exist in ~/.ezdeb/overlay.json
+const overlayCatalog = "overlay"
+
+func overlayPath() (string, error) {
+	dir, err := ezdebDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "overlay.json"), nil
+}
+
+func readOverlay() ([]map[string]interface{}, error) {
+	// read the user overlay, it has the same format as a catalog
+	path, err := overlayPath()
+	if err != nil {
+		return nil, err
+	}
+	packages, err := readCatalogFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return packages, err
+}
+
+func applyOverlay(entries []catalogEntry, overlay []map[string]interface{}) []catalogEntry {
+	// merge the overlay on top of the catalog entries field by field
+	// an overlay entry named package patches that package in every catalog, catalog/package only in that catalog
+	// "hidden": true removes the entry, a null field removes the field
+	// entries that match nothing are added as packages of the overlay catalog, searched before all others
+	var added []catalogEntry
+	for _, patch := range overlay {
+		catalog, name := splitPackageName(catalogString(patch, "name"))
+		if name == "" {
+			continue
+		}
+
+		matched := false
+		for i := range entries {
+			if entries[i].name() != name || (catalog != "" && entries[i].catalog != catalog) {
+				continue
+			}
+			matched = true
+			entries[i] = overlayEntry(entries[i], patch)
+		}
+
+		if !matched && catalog == "" {
+			entry := overlayEntry(catalogEntry{catalog: overlayCatalog, fields: map[string]interface{}{}}, patch)
+			added = append(added, entry)
+		}
+	}
+
+	merged := append(added, entries...)
+	visible := merged[:0]
+	for _, e := range merged {
+		if hidden, _ := e.fields["hidden"].(bool); !hidden {
+			visible = append(visible, e)
+		}
+	}
+	return visible
+}
+
+func overlayEntry(entry catalogEntry, patch map[string]interface{}) catalogEntry {
+	// return a copy of the entry with the patch applied and the patched fields recorded
+	fields := make(map[string]interface{}, len(entry.fields)+len(patch))
+	for k, v := range entry.fields {
+		fields[k] = v
+	}
+
+	overlaid := append([]string{}, entry.overlaid...)
+	for k, v := range patch {
+		if k == "name" {
+			continue
+		}
+		if v == nil {
+			delete(fields, k)
+		} else {
+			fields[k] = v
+		}
+		overlaid = append(overlaid, k)
+	}
+	sort.Strings(overlaid)
+
+	// keep the plain name, the overlay may have qualified it with the catalog
+	_, name := splitPackageName(catalogString(patch, "name"))
+	fields["name"] = name
+
+	return catalogEntry{catalog: entry.catalog, fields: fields, overlaid: overlaid}
+}
+
+func appendOverlay(pkg *Package) error {
+	// add an entry to the user overlay, creating the overlay if needed
+	path, err := overlayPath()
+	if err != nil {
+		return err
+	}
+
+	file := map[string]interface{}{"schema_version": catalogSchemaVersion}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	packages, _ := file["packages"].([]interface{})
+	for _, p := range packages {
+		if m, ok := p.(map[string]interface{}); ok && catalogString(m, "name") == pkg.Name {
+			return fmt.Errorf("%s already has an entry for %s", path, pkg.Name)
+		}
+	}
+	file["packages"] = append(packages, pkg)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
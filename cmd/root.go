@@ -5,16 +5,14 @@ Copyright © 2023 Tony
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
-	"net/http"
-	"io/ioutil"
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"github.com/sirupsen/logrus"
 )
 
@@ -28,6 +26,9 @@ const (
 	Reset = "\033[0m"
 )
 
+// verbose is set by the --verbose flag on every command
+var verbose bool
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "ezdeb",
@@ -41,13 +42,8 @@ var rootCmd = &cobra.Command{
 }
 
 func checkAppUpdate(url string, content string) (bool, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
+	// the version file is read over http(s) or from a local mirror
+	body, err := readLocation(context.Background(), url)
 	if err != nil {
 		return false, err
 	}
@@ -69,12 +65,16 @@ func Execute() {
 	// check if application is up to date
 	// compare commit hash from version file in repository
 	// if different then show msg alerting user to update
-	check, err := checkAppUpdate("https://gitlab.com/Charlie-117/ezdeb/-/raw/master/release/version", "90985c299a5f5e28a44e7f7b7a3d68c5118cb5ed")
-	if err != nil {
-		fmt.Println(Red, "\n\nError checking for App update: " + err.Error() + Reset)
-	}
-	if !check {
-		fmt.Println(Yellow, "\n\n******\n\nAn update is available for EZDEB, please refer to guide for upgrading.\n\n******", Reset)
+	// the version url is configurable and can be turned off on hosts without access
+	// notices go to stderr so they never end up in piped output, e.g. ezdeb export
+	if url := versionURL(); url != "" {
+		check, err := checkAppUpdate(url, "90985c299a5f5e28a44e7f7b7a3d68c5118cb5ed")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, Red, "\n\nError checking for App update: " + err.Error() + Reset)
+		}
+		if !check {
+			fmt.Fprintln(os.Stderr, Yellow, "\n\n******\n\nAn update is available for EZDEB, please refer to guide for upgrading.\n\n******", Reset)
+		}
 	}
 
 	homeDir, err := os.UserHomeDir()
@@ -86,40 +86,27 @@ func Execute() {
 	// check if packageList is updated within 24 hrs or not
 	listPath := filepath.Join(homeDir, ".ezdeb", "pkglist.json")
 	fileInfo, err := os.Stat(listPath)
+	if err != nil {
+		return
+	}
 
 	listModTime := fileInfo.ModTime()
 	listAge := time.Since(listModTime)
 
 	if listAge > 24 * time.Hour {
-		fmt.Println(Yellow, "\n\n******\n\nPackage list is older than 24 hours. Run 'ezdeb sync' to update the package list.\n\n******", Reset)
+		fmt.Fprintln(os.Stderr, Yellow, "\n\n******\n\nPackage list is older than 24 hours. Run 'ezdeb sync' to update the package list.\n\n******", Reset)
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
-}
-
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
-	// Find home directory.
-	home, err := os.UserHomeDir()
-	cobra.CheckErr(err)
-	configPath := filepath.Join(home, ".ezdeb")
-
-	// Search config in home/.ezdeb directory with name "pkglist" (without extension).
-	viper.AddConfigPath(configPath)
-	viper.SetConfigType("json")
-	viper.SetConfigName("pkglist")
-
-	viper.AutomaticEnv() // read in environment variables that match
-
-	// If a config file is found, read it in.
-	// else print a msg and sync it
-	if err := viper.ReadInConfig(); err != nil {
-		fmt.Println(Yellow, "******\n\nPackage list does not exist.\nSyncing package list from repository.\n\n******", Reset)
-		syncCmd.Run(rootCmd, []string{})
-		panic(fmt.Errorf("Run the command again, if it doesn't work then contact us with the debug message"))
-	}
+	cobra.OnInitialize(initSettings, initArch)
+
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Show more details, e.g. why release assets were rejected")
+	rootCmd.PersistentFlags().StringVar(&archOverride, "arch", "", "Fetch packages for this Debian architecture instead of the host one")
+	rootCmd.PersistentFlags().StringSlice("catalog-url", nil, "Upstream catalog url, file:// path or directory, repeat to add mirrors tried in order")
+	rootCmd.PersistentFlags().String("version-url", "", "Url or path of the ezdeb version file, none turns the update check off")
+	rootCmd.PersistentFlags().Bool("wait", false, "Wait for another running ezdeb instead of failing")
+	rootCmd.PersistentFlags().Bool("no-wait", false, "Fail right away if another ezdeb is running, overrides --wait")
 }
 
 // logger function
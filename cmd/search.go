@@ -9,31 +9,28 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var searchRsltCount = 0
 
-func searchName(searchTerm string, packages []interface{}) bool {
+func searchName(searchTerm string, packages []catalogEntry) bool {
 	pkgFound := false
-	for _, pkg := range packages {
-		pkgMap := pkg.(map[string]interface{})
-		if strings.Contains(strings.ToLower(pkgMap["name"].(string)), searchTerm) {
+	for _, entry := range packages {
+		if strings.Contains(strings.ToLower(entry.pkg.Name), searchTerm) {
 			searchRsltCount++
-			fmt.Println(Cyan, pkgMap["name"], Reset, " - ", pkgMap["description"], "\n")
+			printCatalogEntry(entry)
 			pkgFound = true
 		}
 	}
 	return pkgFound
 }
 
-func searchDesc(searchTerm string, packages []interface{}) bool {
+func searchDesc(searchTerm string, packages []catalogEntry) bool {
 	pkgFound := false
-	for _, pkg := range packages {
-		pkgMap := pkg.(map[string]interface{})
-		if strings.Contains(strings.ToLower(pkgMap["description"].(string)), searchTerm) {
+	for _, entry := range packages {
+		if strings.Contains(strings.ToLower(entry.pkg.Description), searchTerm) {
 			searchRsltCount++
-			fmt.Println(Cyan, pkgMap["name"], Reset, " - ", pkgMap["description"], "\n")
+			printCatalogEntry(entry)
 			pkgFound = true
 		}
 	}
@@ -54,8 +51,8 @@ Usage: ezdeb search <search_term>`,
 			return
 		}
 
-		fmt.Println("Searching through packages...\n")
-		packages := viper.Get("packages").([]interface{})
+		fmt.Print("Searching through packages...\n\n")
+		packages := catalogPackages()
 		pkgFound := false
 
 		// search for the the whole args as one in package names
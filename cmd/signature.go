@@ -0,0 +1,291 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+)
+
+const keyserverURL = "https://keys.openpgp.org/vks/v1/by-fingerprint/"
+
+func fetchSignature(ctx context.Context, src Source, rel Release, exts ...string) ([]byte, string, error) {
+	// find the detached signature of the selected asset
+	// use the release file if it is listed, otherwise try the asset url with the extension
+	for _, ext := range exts {
+		name := rel.Asset.Name + ext
+		url := rel.Asset.URL + ext
+		for _, a := range rel.Assets {
+			if a.Name == name {
+				url = a.URL
+				break
+			}
+		}
+
+		data, status, err := src.Fetch(ctx, url)
+		if status == http.StatusNotFound || status == http.StatusForbidden {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return data, name, nil
+	}
+
+	return nil, "", fmt.Errorf("no %s signature found for %s", strings.Join(exts, "/"), rel.Asset.Name)
+}
+
+func readKeyMaterial(ctx context.Context, key string) ([]byte, error) {
+	// a key in the catalog is either the key itself or an https url to it
+	if strings.HasPrefix(key, "https://") {
+		data, _, err := httpGet(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch key: %v", err)
+		}
+		return data, nil
+	}
+	return []byte(key), nil
+}
+
+func normalizeFingerprint(fpr string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(fpr, "0x"), " ", ""))
+}
+
+func gpgKeyring(ctx context.Context, pkg *Package) (openpgp.EntityList, string, error) {
+	// read the OpenPGP key declared in the catalog and the fingerprint it must have
+	// without gpg_key the key is fetched from the keyserver by its fingerprint
+	fingerprint := normalizeFingerprint(pkg.GPGFingerprint)
+	key := pkg.GPGKey
+	if key == "" {
+		if fingerprint == "" {
+			return nil, "", fmt.Errorf("gpg_key or gpg_fingerprint is required")
+		}
+		key = keyserverURL + fingerprint
+	}
+
+	material, err := readKeyMaterial(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(material))
+	if err != nil {
+		if keyring, err = openpgp.ReadKeyRing(bytes.NewReader(material)); err != nil {
+			return nil, "", fmt.Errorf("failed to read gpg key: %v", err)
+		}
+	}
+	return keyring, fingerprint, nil
+}
+
+func gpgIdentity(signer *openpgp.Entity, fingerprint string, signed string) (string, error) {
+	// name the key that made a verified signature, it must have the declared fingerprint
+	signerFpr := strings.ToUpper(hex.EncodeToString(signer.PrimaryKey.Fingerprint))
+	if fingerprint != "" && signerFpr != fingerprint {
+		return "", fmt.Errorf("%s was signed by %s, expected %s", signed, signerFpr, fingerprint)
+	}
+
+	identity := "gpg " + signerFpr
+	for name := range signer.Identities {
+		identity += " (" + name + ")"
+		break
+	}
+	return identity, nil
+}
+
+func verifyGPG(ctx context.Context, pkg *Package, src Source, rel Release, data []byte) (string, error) {
+	// check an OpenPGP detached signature, armored or binary
+	// the signing key must have the fingerprint declared in the catalog
+	keyring, fingerprint, err := gpgKeyring(ctx, pkg)
+	if err != nil {
+		return "", err
+	}
+
+	sig, sigName, err := fetchSignature(ctx, src, rel, ".asc", ".sig", ".gpg")
+	if err != nil {
+		return "", err
+	}
+
+	var signer *openpgp.Entity
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(sig), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return "", fmt.Errorf("bad gpg signature %s: %v", sigName, err)
+	}
+
+	return gpgIdentity(signer, fingerprint, rel.Asset.Name)
+}
+
+func verifyMinisign(ctx context.Context, pkg *Package, src Source, rel Release, data []byte) (string, error) {
+	sig, sigName, err := fetchSignature(ctx, src, rel, ".minisig")
+	if err != nil {
+		return "", err
+	}
+
+	keyID, err := minisignVerify(pkg.MinisignKey, data, sig)
+	if err != nil {
+		return "", fmt.Errorf("bad minisign signature %s: %v", sigName, err)
+	}
+	return "minisign " + keyID, nil
+}
+
+func minisignVerify(publicKey string, data []byte, sig []byte) (string, error) {
+	// check a minisign signature and its trusted comment, return the key id
+	// the public key is the base64 line of a minisign .pub file
+	pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(pub) != 42 || string(pub[:2]) != "Ed" {
+		return "", fmt.Errorf("invalid minisign public key")
+	}
+
+	// untrusted comment, signature, trusted comment, global signature
+	lines := strings.Split(strings.ReplaceAll(string(sig), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", fmt.Errorf("malformed signature file")
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sigBytes) != 74 {
+		return "", fmt.Errorf("malformed signature")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed global signature")
+	}
+
+	if !bytes.Equal(sigBytes[2:10], pub[2:10]) {
+		return "", fmt.Errorf("signed with another key")
+	}
+
+	// "ED" signatures sign the blake2b-512 hash of the file instead of the file
+	message := data
+	switch string(sigBytes[:2]) {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	default:
+		return "", fmt.Errorf("unsupported signature algorithm")
+	}
+
+	key := ed25519.PublicKey(pub[10:])
+	if !ed25519.Verify(key, message, sigBytes[10:]) {
+		return "", fmt.Errorf("signature does not match")
+	}
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(key, append(append([]byte{}, sigBytes[10:]...), trusted...), globalSig) {
+		return "", fmt.Errorf("trusted comment does not match")
+	}
+
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(pub[2:10])), nil
+}
+
+func verifyCosign(ctx context.Context, pkg *Package, src Source, rel Release, data []byte) (string, error) {
+	// check a cosign sign-blob signature made with a key pair
+	material, err := readKeyMaterial(ctx, pkg.CosignKey)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(material)
+	if block == nil {
+		return "", fmt.Errorf("cosign_key is not a PEM public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cosign key: %v", err)
+	}
+
+	sig, sigName, err := fetchSignature(ctx, src, rel, ".sig")
+	if err != nil {
+		return "", err
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return "", fmt.Errorf("malformed cosign signature %s", sigName)
+	}
+
+	digest := sha256.Sum256(data)
+	ok := false
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest[:], sigBytes)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sigBytes)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sigBytes) == nil
+	}
+	if !ok {
+		return "", fmt.Errorf("bad cosign signature %s", sigName)
+	}
+
+	keySum := sha256.Sum256(block.Bytes)
+	return "cosign sha256:" + hex.EncodeToString(keySum[:]), nil
+}
+
+func verifySignature(ctx context.Context, pkg *Package, src Source, rel Release, location string) (string, error) {
+	// verify the detached signatures for every key the catalog entry declares
+	// return the verified key identities and the key that signed the source index, empty if there is none
+	var identities []string
+	var data []byte
+
+	verifiers := []struct {
+		fields []string
+		verify func(context.Context, *Package, Source, Release, []byte) (string, error)
+	}{
+		{[]string{"gpg_fingerprint", "gpg_key"}, verifyGPG},
+		{[]string{"minisign_key"}, verifyMinisign},
+		{[]string{"cosign_key"}, verifyCosign},
+	}
+
+	for _, v := range verifiers {
+		// the gpg key of an apt repository signs its index, which the source already checked
+		if pkg.Source == "aptrepo" && v.fields[0] == "gpg_fingerprint" {
+			continue
+		}
+		declared := false
+		for _, field := range v.fields {
+			if pkg.field(field) != "" {
+				declared = true
+			}
+		}
+		if !declared {
+			continue
+		}
+
+		if data == nil {
+			var err error
+			if data, err = os.ReadFile(location); err != nil {
+				return "", fmt.Errorf("failed to read %s: %v", location, err)
+			}
+		}
+
+		identity, err := v.verify(ctx, pkg, src, rel, data)
+		if err != nil {
+			return "", err
+		}
+		identities = append(identities, identity)
+	}
+
+	if rel.SignedBy != "" {
+		identities = append(identities, rel.SignedBy)
+	}
+	return strings.Join(identities, ", "), nil
+}
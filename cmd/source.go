@@ -0,0 +1,180 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string
+	URL  string
+	Size int64
+}
+
+// Release is the latest .deb offered by a source
+type Release struct {
+	// Tag is the release tag, empty if the source has no tags
+	Tag string
+	// Version is the upstream version if the source knows it without downloading the .deb
+	Version string
+	// DebVersion is the full Debian version if the source publishes it, e.g. in an apt index
+	DebVersion string
+	// SHA256 is the expected checksum of the selected .deb if the source publishes it
+	SHA256 string
+	// SignedBy names the key that signed the index the source read the release from, e.g. an apt InRelease
+	SignedBy string
+	// Asset is the .deb selected for installation
+	Asset Asset
+	// Assets holds every file attached to the release
+	Assets []Asset
+}
+
+// Source is a place ezdeb can fetch the .deb of a catalog package from
+type Source interface {
+	// Latest resolves the newest release and selects its .deb
+	Latest(ctx context.Context) (Release, error)
+	// Download saves the selected .deb of a release to dst
+	Download(ctx context.Context, rel Release, dst string) error
+	// Fetch downloads a small file published with a release, e.g. a checksum file or a signature
+	// it authenticates like Download and returns the status code for missing files
+	Fetch(ctx context.Context, url string) ([]byte, int, error)
+	// String describes where the source points to
+	String() string
+}
+
+// sourceFactory builds a Source from a catalog entry
+type sourceFactory func(pkg *Package) (Source, error)
+
+var sourceFactories = map[string]sourceFactory{}
+
+// sourceFields lists the catalog fields each source type requires
+var sourceFields = map[string][]string{}
+
+// registerSource makes a source type available to catalog entries with "source": name
+// required names the catalog fields an entry of this source type must set
+func registerSource(name string, factory sourceFactory, required ...string) {
+	sourceFactories[name] = factory
+	sourceFields[name] = required
+}
+
+func newSource(pkg *Package) (Source, error) {
+	// build the source of a catalog entry based on its "source" field
+	factory, ok := sourceFactories[pkg.Source]
+	if !ok {
+		return nil, fmt.Errorf("unknown source type %q", pkg.Source)
+	}
+	for _, field := range sourceFields[pkg.Source] {
+		if pkg.field(field) == "" {
+			return nil, fmt.Errorf("catalog entry %q is missing field %q", pkg.Name, field)
+		}
+	}
+	return factory(pkg)
+}
+
+func catalogString(pkgMap map[string]interface{}, key string) string {
+	// get a string field of a raw catalog entry, empty if missing or not a string
+	value, _ := pkgMap[key].(string)
+	return value
+}
+
+func httpGet(ctx context.Context, url string) ([]byte, int, error) {
+	return httpGetHeader(ctx, url, nil)
+}
+
+func httpGetHeader(ctx context.Context, url string, header http.Header) ([]byte, int, error) {
+	// download a small file into memory, return the status code for missing files
+	// header is added to the request, e.g. for sources that need a token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	// nothing fetched this way should be larger than a few megabytes
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	return body, resp.StatusCode, nil
+}
+
+func downloadFile(ctx context.Context, url string, dst string, header http.Header) error {
+	// download url to dst and show a progress bar
+	// header is added to the request, e.g. for sources that need a token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download package: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download package: %s", resp.Status)
+	}
+
+	// create progress bar and set it to the number of bytes downloaded
+	bar := progressbar.DefaultBytes(
+		resp.ContentLength,
+		"downloading",
+	)
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer f.Close()
+
+	// write content to file
+	_, err = io.Copy(io.MultiWriter(f, bar), resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write to temporary file: %v", err)
+	}
+
+	return nil
+}
+
+func sha256File(location string) (string, error) {
+	// return the hex encoded SHA256 of a file
+	f, err := os.Open(location)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,254 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ulikunitz/xz"
+)
+
+// allowUnsignedRepo is set by --allow-unsigned-repo to accept apt repositories without a gpg key
+var allowUnsignedRepo bool
+
+// aptRepoSource fetches a package from an apt repository without adding it to sources.list
+// the InRelease index lists the Packages indexes with their checksums and
+// the Packages index lists every .deb with its version, path and checksum
+// InRelease must be signed by the gpg_key or gpg_fingerprint of the catalog entry
+type aptRepoSource struct {
+	repoURL   string
+	suite     string
+	component string
+	pkg       string
+	entry     *Package
+}
+
+// aptIndexFile is an index listed in the SHA256 field of a Release file
+type aptIndexFile struct {
+	sha256 string
+	size   int64
+}
+
+func init() {
+	registerSource("aptrepo", newAptRepoSource, "repo_url", "suite")
+}
+
+func newAptRepoSource(pkg *Package) (Source, error) {
+	component := pkg.Component
+	if component == "" {
+		component = "main"
+	}
+
+	return &aptRepoSource{
+		repoURL:   strings.TrimSuffix(pkg.RepoURL, "/"),
+		suite:     pkg.Suite,
+		component: component,
+		pkg:       pkg.debName(),
+		entry:     pkg,
+	}, nil
+}
+
+func (s *aptRepoSource) signed() bool {
+	return s.entry.GPGKey != "" || s.entry.GPGFingerprint != ""
+}
+
+func (s *aptRepoSource) verifyRelease(ctx context.Context, check func(openpgp.KeyRing) (*openpgp.Entity, error)) (string, error) {
+	// check the signature of the suite against the key of the catalog entry
+	keyring, fingerprint, err := gpgKeyring(ctx, s.entry)
+	if err != nil {
+		return "", err
+	}
+	signer, err := check(keyring)
+	if err != nil {
+		return "", fmt.Errorf("bad signature on release file of %s: %v", s.suite, err)
+	}
+	return gpgIdentity(signer, fingerprint, "release file of "+s.suite)
+}
+
+func (s *aptRepoSource) releaseIndex(ctx context.Context) (map[string]aptIndexFile, string, error) {
+	// read the SHA256 list of the suite from InRelease, or Release and Release.gpg for repositories without it
+	// return the key that signed it, unsigned suites are refused unless --allow-unsigned-repo is set
+	if !s.signed() && !allowUnsignedRepo {
+		return nil, "", fmt.Errorf("apt repository %s has no gpg_key or gpg_fingerprint in the catalog, use --allow-unsigned-repo to install it unverified", s.repoURL)
+	}
+	distURL := fmt.Sprintf("%s/dists/%s", s.repoURL, s.suite)
+
+	var text []byte
+	signedBy := ""
+	data, status, err := httpGet(ctx, distURL+"/InRelease")
+	switch {
+	case status == http.StatusNotFound:
+		if text, _, err = httpGet(ctx, distURL+"/Release"); err != nil {
+			return nil, "", err
+		}
+		if s.signed() {
+			sig, _, err := httpGet(ctx, distURL+"/Release.gpg")
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to get signature of %s: %v", s.suite, err)
+			}
+			signedBy, err = s.verifyRelease(ctx, func(keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+				if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN PGP SIGNATURE-----")) {
+					return openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(text), bytes.NewReader(sig), nil)
+				}
+				return openpgp.CheckDetachedSignature(keyring, bytes.NewReader(text), bytes.NewReader(sig), nil)
+			})
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	case err != nil:
+		return nil, "", err
+	default:
+		block, _ := clearsign.Decode(data)
+		if block == nil {
+			return nil, "", fmt.Errorf("InRelease of %s is not clearsigned", s.suite)
+		}
+		text = block.Plaintext
+		if s.signed() {
+			signedBy, err = s.verifyRelease(ctx, func(keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+				return block.VerifySignature(keyring, nil)
+			})
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	paragraphs, err := parseDeb822(bytes.NewReader(text))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(paragraphs) == 0 || paragraphs[0]["sha256"] == "" {
+		return nil, "", fmt.Errorf("no SHA256 index list in release file of %s", s.suite)
+	}
+
+	files := make(map[string]aptIndexFile)
+	for _, line := range strings.Split(paragraphs[0]["sha256"], "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		files[parts[2]] = aptIndexFile{sha256: strings.ToLower(parts[0]), size: size}
+	}
+
+	return files, signedBy, nil
+}
+
+func (s *aptRepoSource) packagesIndex(ctx context.Context, files map[string]aptIndexFile) ([]map[string]string, error) {
+	// download the best compressed Packages index for our architecture and check its checksum
+	for _, ext := range []string{".xz", ".gz", ""} {
+		name := fmt.Sprintf("%s/binary-%s/Packages%s", s.component, debArch(), ext)
+		index, ok := files[name]
+		if !ok {
+			continue
+		}
+
+		data, _, err := httpGet(ctx, fmt.Sprintf("%s/dists/%s/%s", s.repoURL, s.suite, name))
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != index.sha256 {
+			return nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+
+		var r io.Reader = bytes.NewReader(data)
+		switch ext {
+		case ".xz":
+			if r, err = xz.NewReader(r); err != nil {
+				return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+			}
+		case ".gz":
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+
+		return parseDeb822(r)
+	}
+
+	return nil, fmt.Errorf("no Packages index for %s/binary-%s in %s", s.component, debArch(), s.suite)
+}
+
+func (s *aptRepoSource) Latest(ctx context.Context) (Release, error) {
+	files, signedBy, err := s.releaseIndex(ctx)
+	if err != nil {
+		return Release{}, err
+	}
+
+	packages, err := s.packagesIndex(ctx, files)
+	if err != nil {
+		return Release{}, err
+	}
+
+	// pick the highest version built for our architecture
+	var best map[string]string
+	for _, p := range packages {
+		if p["package"] != s.pkg || !archMatches(p["architecture"]) {
+			continue
+		}
+		if p["filename"] == "" || p["sha256"] == "" {
+			continue
+		}
+		if best == nil {
+			best = p
+			continue
+		}
+		if cmp, err := compareDebVersions(p["version"], best["version"]); err == nil && cmp > 0 {
+			best = p
+		}
+	}
+	if best == nil {
+		return Release{}, fmt.Errorf("package %s not found for %s in %s/%s", s.pkg, debArch(), s.suite, s.component)
+	}
+
+	size, _ := strconv.ParseInt(best["size"], 10, 64)
+	asset := Asset{
+		Name: path.Base(best["filename"]),
+		URL:  s.repoURL + "/" + strings.TrimPrefix(best["filename"], "/"),
+		Size: size,
+	}
+
+	return Release{
+		DebVersion: best["version"],
+		SHA256:     strings.ToLower(best["sha256"]),
+		SignedBy:   signedBy,
+		Asset:      asset,
+		Assets:     []Asset{asset},
+	}, nil
+}
+
+func (s *aptRepoSource) Download(ctx context.Context, rel Release, dst string) error {
+	// the SHA256 from the index is checked by fetchRelease
+	return downloadFile(ctx, rel.Asset.URL, dst, nil)
+}
+
+func (s *aptRepoSource) Fetch(ctx context.Context, url string) ([]byte, int, error) {
+	return httpGet(ctx, url)
+}
+
+func (s *aptRepoSource) String() string {
+	return fmt.Sprintf("%s %s/%s %s", s.repoURL, s.suite, s.component, s.pkg)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// giteaSource fetches .deb attachments from the latest release of a Gitea or Forgejo repository
+// a token for private repositories is read from EZDEB_GITEA_TOKEN
+type giteaSource struct {
+	host  string
+	owner string
+	repo  string
+	token string
+	rules assetRules
+}
+
+// giteaRelease is the part of the Gitea releases API response ezdeb uses
+type giteaRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name               string `json:"name"`
+		Size               int64  `json:"size"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func init() {
+	registerSource("gitea", newGiteaSource, "host", "owner", "repo")
+}
+
+func newGiteaSource(pkg *Package) (Source, error) {
+	// accept a bare host name as well as a full base url
+	host := strings.TrimSuffix(pkg.Host, "/")
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "https://" + host
+	}
+
+	rules, err := newAssetRules(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &giteaSource{
+		host:  host,
+		owner: pkg.Owner,
+		repo:  pkg.Repo,
+		token: os.Getenv("EZDEB_GITEA_TOKEN"),
+		rules: rules,
+	}, nil
+}
+
+func (s *giteaSource) header() http.Header {
+	header := http.Header{}
+	if s.token != "" {
+		header.Set("Authorization", "token "+s.token)
+	}
+	return header
+}
+
+func (s *giteaSource) Latest(ctx context.Context) (Release, error) {
+	apiURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/releases/latest",
+		s.host, url.PathEscape(s.owner), url.PathEscape(s.repo))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header = s.header()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to get latest release: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Release{}, fmt.Errorf("failed to get latest release: %s", resp.Status)
+	}
+
+	var giteaRel giteaRelease
+	if err := json.NewDecoder(resp.Body).Decode(&giteaRel); err != nil {
+		return Release{}, fmt.Errorf("failed to parse release: %v", err)
+	}
+
+	rel := Release{
+		Tag:     giteaRel.TagName,
+		Version: versionFromTag(giteaRel.TagName),
+	}
+	for _, a := range giteaRel.Assets {
+		rel.Assets = append(rel.Assets, Asset{Name: a.Name, URL: a.BrowserDownloadURL, Size: a.Size})
+	}
+
+	// find deb file asset
+	rel.Asset, err = selectDebAsset(rel.Assets, s.rules)
+	if err != nil {
+		return Release{}, err
+	}
+
+	return rel, nil
+}
+
+func (s *giteaSource) Download(ctx context.Context, rel Release, dst string) error {
+	// only send the token to the Gitea instance itself
+	var header http.Header
+	if strings.HasPrefix(rel.Asset.URL, s.host+"/") {
+		header = s.header()
+	}
+	return downloadFile(ctx, rel.Asset.URL, dst, header)
+}
+
+func (s *giteaSource) Fetch(ctx context.Context, url string) ([]byte, int, error) {
+	// only send the token to the Gitea instance itself
+	var header http.Header
+	if strings.HasPrefix(url, s.host+"/") {
+		header = s.header()
+	}
+	return httpGetHeader(ctx, url, header)
+}
+
+func (s *giteaSource) String() string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimPrefix(strings.TrimPrefix(s.host, "https://"), "http://"), s.owner, s.repo)
+}
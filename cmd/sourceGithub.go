@@ -0,0 +1,77 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v50/github"
+)
+
+// githubSource fetches .deb assets from the latest GitHub release of a repository
+type githubSource struct {
+	user  string
+	repo  string
+	rules assetRules
+}
+
+func init() {
+	registerSource("github", newGithubSource, "ghuser", "ghrepo")
+}
+
+func newGithubSource(pkg *Package) (Source, error) {
+	rules, err := newAssetRules(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return &githubSource{
+		user:  pkg.GHUser,
+		repo:  pkg.GHRepo,
+		rules: rules,
+	}, nil
+}
+
+func (s *githubSource) Latest(ctx context.Context) (Release, error) {
+	client := github.NewClient(nil)
+
+	// get latest release
+	ghRelease, _, err := client.Repositories.GetLatestRelease(ctx, s.user, s.repo)
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to get latest release: %v", err)
+	}
+
+	rel := Release{
+		Tag:     ghRelease.GetTagName(),
+		Version: versionFromTag(ghRelease.GetTagName()),
+	}
+	for _, a := range ghRelease.Assets {
+		rel.Assets = append(rel.Assets, Asset{
+			Name: a.GetName(),
+			URL:  a.GetBrowserDownloadURL(),
+			Size: int64(a.GetSize()),
+		})
+	}
+
+	// find deb file asset
+	rel.Asset, err = selectDebAsset(rel.Assets, s.rules)
+	if err != nil {
+		return Release{}, err
+	}
+
+	return rel, nil
+}
+
+func (s *githubSource) Download(ctx context.Context, rel Release, dst string) error {
+	return downloadFile(ctx, rel.Asset.URL, dst, nil)
+}
+
+func (s *githubSource) Fetch(ctx context.Context, url string) ([]byte, int, error) {
+	return httpGet(ctx, url)
+}
+
+func (s *githubSource) String() string {
+	return fmt.Sprintf("github.com/%s/%s", s.user, s.repo)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultGitlabURL = "https://gitlab.com"
+
+// gitlabSource fetches .deb files from the latest release of a GitLab project
+// the instance can be self-hosted, a token is read from EZDEB_GITLAB_TOKEN or GITLAB_TOKEN
+type gitlabSource struct {
+	baseURL string
+	project string
+	token   string
+	rules   assetRules
+}
+
+// gitlabRelease is the part of the GitLab Releases API response ezdeb uses
+type gitlabRelease struct {
+	TagName string `json:"tag_name"`
+	// UpcomingRelease is set for releases whose released_at is in the future
+	UpcomingRelease bool      `json:"upcoming_release"`
+	ReleasedAt      time.Time `json:"released_at"`
+	Assets          struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+			LinkType       string `json:"link_type"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+func init() {
+	registerSource("gitlab", newGitlabSource, "gitlab_project")
+}
+
+func newGitlabSource(pkg *Package) (Source, error) {
+	baseURL := pkg.GitlabURL
+	if baseURL == "" {
+		baseURL = defaultGitlabURL
+	}
+
+	token := os.Getenv("EZDEB_GITLAB_TOKEN")
+	if token == "" {
+		token = os.Getenv("GITLAB_TOKEN")
+	}
+
+	rules, err := newAssetRules(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitlabSource{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		project: pkg.GitlabProject,
+		token:   token,
+		rules:   rules,
+	}, nil
+}
+
+func (s *gitlabSource) header() http.Header {
+	header := http.Header{}
+	if s.token != "" {
+		header.Set("PRIVATE-TOKEN", s.token)
+	}
+	return header
+}
+
+func (s *gitlabSource) Latest(ctx context.Context) (Release, error) {
+	// the project can be a numeric id or a path like group/project
+	// the newest releases can be upcoming ones that are not published yet, so fetch a page of them
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/releases?order_by=released_at&sort=desc&per_page=20",
+		s.baseURL, url.PathEscape(s.project))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header = s.header()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to get latest release: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Release{}, fmt.Errorf("failed to get latest release: %s", resp.Status)
+	}
+
+	var releases []gitlabRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return Release{}, fmt.Errorf("failed to parse releases: %v", err)
+	}
+	var latest *gitlabRelease
+	for i, r := range releases {
+		if !r.UpcomingRelease && !r.ReleasedAt.After(time.Now()) {
+			latest = &releases[i]
+			break
+		}
+	}
+	if latest == nil {
+		return Release{}, fmt.Errorf("no published releases found for %s", s.project)
+	}
+
+	rel := Release{
+		Tag:     latest.TagName,
+		Version: versionFromTag(latest.TagName),
+	}
+
+	// release links can point anywhere, generic packages are links into the package registry
+	// prefer the permanent direct asset url and fall back to the file name in the url
+	for _, link := range latest.Assets.Links {
+		assetURL := link.DirectAssetURL
+		if assetURL == "" {
+			assetURL = link.URL
+		}
+		name := link.Name
+		if fileName := path.Base(strings.SplitN(link.URL, "?", 2)[0]); path.Ext(name) != ".deb" && path.Ext(fileName) == ".deb" {
+			name = fileName
+		}
+		rel.Assets = append(rel.Assets, Asset{Name: name, URL: assetURL})
+	}
+
+	rel.Asset, err = selectDebAsset(rel.Assets, s.rules)
+	if err != nil {
+		return Release{}, err
+	}
+
+	return rel, nil
+}
+
+func (s *gitlabSource) Download(ctx context.Context, rel Release, dst string) error {
+	// only send the token to the GitLab instance itself
+	var header http.Header
+	if strings.HasPrefix(rel.Asset.URL, s.baseURL+"/") {
+		header = s.header()
+	}
+	return downloadFile(ctx, rel.Asset.URL, dst, header)
+}
+
+func (s *gitlabSource) Fetch(ctx context.Context, url string) ([]byte, int, error) {
+	// only send the token to the GitLab instance itself
+	var header http.Header
+	if strings.HasPrefix(url, s.baseURL+"/") {
+		header = s.header()
+	}
+	return httpGetHeader(ctx, url, header)
+}
+
+func (s *gitlabSource) String() string {
+	return fmt.Sprintf("%s/%s", strings.TrimPrefix(strings.TrimPrefix(s.baseURL, "https://"), "http://"), s.project)
+}
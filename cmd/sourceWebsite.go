@@ -0,0 +1,107 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// websiteSource fetches a .deb from a fixed link or from the .deb files linked on a page
+type websiteSource struct {
+	link  string
+	rules assetRules
+}
+
+func init() {
+	registerSource("website", newWebsiteSource, "link")
+}
+
+func newWebsiteSource(pkg *Package) (Source, error) {
+	// older catalogs used "url" instead of "link", parseCatalog upgrades them
+	rules, err := newAssetRules(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return &websiteSource{link: pkg.Link, rules: rules}, nil
+}
+
+func (s *websiteSource) Latest(ctx context.Context) (Release, error) {
+	url := s.link
+
+	// if the url is a dynamic url i.e it keeps changing the .deb name then
+	// we need to search for the package in the page and get the url of the .deb file
+	if !strings.Contains(url, ".deb") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return Release{}, fmt.Errorf("failed to get download link: %v", err)
+		}
+		pageResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return Release{}, fmt.Errorf("failed to get download link: %v", err)
+		}
+		defer pageResp.Body.Close()
+
+		// if the page redirected straight to a .deb then use the final url
+		if final := pageResp.Request.URL; strings.HasSuffix(final.Path, ".deb") {
+			url = final.String()
+		} else {
+			// Read the response body into a buffer
+			body, err := io.ReadAll(pageResp.Body)
+			if err != nil {
+				return Release{}, fmt.Errorf("failed to get download link: %v", err)
+			}
+
+			// Find the URLs of the .deb packages linked on the page
+			re := regexp.MustCompile(`"([^"]*\.deb)"`)
+			matches := re.FindAllSubmatch(body, -1)
+			if len(matches) == 0 {
+				return Release{}, fmt.Errorf("No .deb package found in response body")
+			}
+
+			// Construct the download URLs and let the asset rules pick one
+			var assets []Asset
+			seen := make(map[string]bool)
+			for _, m := range matches {
+				link := string(m[1])
+				if !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+					link = fmt.Sprintf("%s/%s", strings.TrimSuffix(url, "/"), strings.TrimPrefix(link, "/"))
+				}
+				if seen[link] {
+					continue
+				}
+				seen[link] = true
+				assets = append(assets, Asset{Name: path.Base(strings.SplitN(link, "?", 2)[0]), URL: link})
+			}
+
+			asset, err := selectDebAsset(assets, s.rules)
+			if err != nil {
+				return Release{}, err
+			}
+			return Release{Asset: asset, Assets: assets}, nil
+		}
+	}
+
+	// websites don't publish a version so it has to be read from the .deb
+	asset := Asset{Name: path.Base(strings.SplitN(url, "?", 2)[0]), URL: url}
+	return Release{Asset: asset, Assets: []Asset{asset}}, nil
+}
+
+func (s *websiteSource) Download(ctx context.Context, rel Release, dst string) error {
+	return downloadFile(ctx, rel.Asset.URL, dst, nil)
+}
+
+func (s *websiteSource) Fetch(ctx context.Context, url string) ([]byte, int, error) {
+	return httpGet(ctx, url)
+}
+
+func (s *websiteSource) String() string {
+	return s.link
+}
@@ -0,0 +1,329 @@
+/*
+Copyright © 2023 Tony
+
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateVersion is the format of state.json written by this ezdeb
+const stateVersion = 1
+
+// pkgState is what ezdeb knows about a package it manages, by the name dpkg installed it as
+type pkgState struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Source  string `json:"source,omitempty"`
+	Catalog string `json:"catalog,omitempty"`
+	// Entry is the catalog entry name if it differs from the dpkg package name
+	Entry string `json:"entry,omitempty"`
+	// Tag is the release tag the package was installed from, empty if the source has no tags
+	Tag      string `json:"tag,omitempty"`
+	AssetURL string `json:"asset_url,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	SignedBy string `json:"signed_by,omitempty"`
+	// Adopted is true if the package was installed without ezdeb, see adopt
+	Adopted     bool       `json:"adopted,omitempty"`
+	InstalledAt time.Time  `json:"installed_at"`
+	Hold        *holdState `json:"hold,omitempty"`
+}
+
+// holdState records that a package is held back from updates
+type holdState struct {
+	Since time.Time `json:"since"`
+}
+
+// ezdebState is the content of ~/.ezdeb/state.json
+type ezdebState struct {
+	Version  int                  `json:"version"`
+	Packages map[string]*pkgState `json:"packages"`
+}
+
+func statePath() (string, error) {
+	dir, err := ezdebDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+func readState() (*ezdebState, error) {
+	// read state.json, on first run it is created from the packages and held folders
+	path, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return migrateState()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+
+	state := &ezdebState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if state.Version > stateVersion {
+		return nil, fmt.Errorf("%s was written by a newer ezdeb, update ezdeb", path)
+	}
+	if state.Packages == nil {
+		state.Packages = make(map[string]*pkgState)
+	}
+	return state, nil
+}
+
+func writeState(state *ezdebState) error {
+	// replace state.json in one step so a failed write never leaves half a state behind
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	state.Version = stateVersion
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return nil
+}
+
+func updateState(change func(state *ezdebState) error) error {
+	// read the state, apply change and write it back if change succeeds
+	state, err := readState()
+	if err != nil {
+		return err
+	}
+	if err := change(state); err != nil {
+		return err
+	}
+	return writeState(state)
+}
+
+func migrateState() (*ezdebState, error) {
+	// build the state from the per package files of older ezdeb versions
+	// packages/<name>.json held the details and held/<name>.json marked a hold
+	// the old folders are moved to ~/.ezdeb/migrated once state.json is written
+	state := &ezdebState{Packages: make(map[string]*pkgState)}
+
+	dir, err := ezdebDir()
+	if err != nil {
+		return nil, err
+	}
+	pkgDir := filepath.Join(dir, "packages")
+	heldDir := filepath.Join(dir, "held")
+
+	legacy := false
+	for _, old := range []string{pkgDir, heldDir} {
+		if _, err := os.Stat(old); err == nil {
+			legacy = true
+		}
+	}
+	if !legacy {
+		return state, nil
+	}
+
+	// the state is only migrated under the ezdeb lock, commands that don't hold it take it here
+	// if another ezdeb holds it the old files are read without migrating them, that ezdeb migrates them
+	migrate := lockHeld
+	if !lockHeld {
+		if release, err := acquireLock(false); err == nil {
+			defer release()
+			migrate = true
+			// another ezdeb may have migrated them before we got the lock
+			if path, err := statePath(); err == nil {
+				if _, err := os.Stat(path); err == nil {
+					return readState()
+				}
+			}
+		}
+	}
+
+	if files, err := ioutil.ReadDir(pkgDir); err == nil {
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+			name := strings.TrimSuffix(file.Name(), ".json")
+			record := &pkgState{Name: name, InstalledAt: file.ModTime().UTC()}
+
+			// the file was written by viper, every value is a string
+			var details map[string]interface{}
+			if data, err := os.ReadFile(filepath.Join(pkgDir, file.Name())); err == nil {
+				json.Unmarshal(data, &details)
+			}
+			record.Catalog = catalogString(details, "catalog")
+			record.SHA256 = catalogString(details, "sha256")
+			record.SignedBy = catalogString(details, "signed_by")
+
+			// "version" held the downloaded file name in older versions, dpkg knows the installed version
+			// a download url is kept as the asset, anything else is resolved again by ezdeb lock
+			record.Version, _ = installedVersion(name)
+			if hint := catalogString(details, "version"); isRemoteLocation(hint) && strings.HasSuffix(hint, ".deb") {
+				record.AssetURL = hint
+			}
+			state.Packages[name] = record
+		}
+	}
+
+	if files, err := ioutil.ReadDir(heldDir); err == nil {
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+			// a hold only counted for packages ezdeb managed
+			if record, found := state.Packages[strings.TrimSuffix(file.Name(), ".json")]; found {
+				record.Hold = &holdState{Since: file.ModTime().UTC()}
+			}
+		}
+	}
+
+	if !migrate {
+		return state, nil
+	}
+
+	if err := writeState(state); err != nil {
+		return nil, err
+	}
+	migratedDir := filepath.Join(dir, "migrated")
+	if err := os.MkdirAll(migratedDir, os.ModePerm); err == nil {
+		for _, old := range []string{pkgDir, heldDir} {
+			if _, err := os.Stat(old); err == nil {
+				os.Rename(old, filepath.Join(migratedDir, filepath.Base(old)))
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, Cyan, "Moved the state of", len(state.Packages), "packages to", filepath.Join(dir, "state.json"), Reset)
+
+	return state, nil
+}
+
+func dpkgName(name string) string {
+	// the name dpkg and the state know a package by, a catalog entry may install a package named differently
+	if entry, found := lookupCatalogEntry(name); found {
+		return entry.pkg.debName()
+	}
+	return name
+}
+
+func packageRecord(catalog string, pkg *Package) *pkgState {
+	// a new record of a catalog package, keyed by the name dpkg installs it as
+	record := &pkgState{Name: pkg.debName(), Source: pkg.Source, Catalog: catalog, InstalledAt: time.Now().UTC()}
+	if pkg.Name != record.Name {
+		record.Entry = pkg.Name
+	}
+	return record
+}
+
+func (r *pkgState) entryName() string {
+	if r.Entry != "" {
+		return r.Entry
+	}
+	return r.Name
+}
+
+func (r *pkgState) qualifiedEntry() string {
+	// the catalog entry the package was installed from as catalog/entry
+	if r.Catalog == "" {
+		return r.entryName()
+	}
+	return r.Catalog + "/" + r.entryName()
+}
+
+func (s *ezdebState) names(held bool) []string {
+	// sorted names of the managed packages, only held ones if held is set
+	var names []string
+	for name, record := range s.Packages {
+		if !held || record.Hold != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func managedPackages() ([]string, error) {
+	// the packages installed or adopted with ezdeb
+	state, err := readState()
+	if err != nil {
+		return nil, err
+	}
+	return state.names(false), nil
+}
+
+func heldPackages() ([]string, error) {
+	state, err := readState()
+	if err != nil {
+		return nil, err
+	}
+	return state.names(true), nil
+}
+
+func readPackageState(pkgName string) (*pkgState, error) {
+	// the record of a managed package, an error if ezdeb doesn't manage it
+	state, err := readState()
+	if err != nil {
+		return nil, err
+	}
+	record, found := state.Packages[pkgName]
+	if !found {
+		return nil, fmt.Errorf("package %s is not managed by ezdeb", pkgName)
+	}
+	return record, nil
+}
+
+func storePackageState(record *pkgState) error {
+	// add or replace the record of a package, an existing hold is kept
+	return updateState(func(state *ezdebState) error {
+		if old, found := state.Packages[record.Name]; found && record.Hold == nil {
+			record.Hold = old.Hold
+		}
+		state.Packages[record.Name] = record
+		return nil
+	})
+}
+
+func removePackageState(pkgName string) error {
+	return updateState(func(state *ezdebState) error {
+		if _, found := state.Packages[pkgName]; !found {
+			return fmt.Errorf("package %s is not managed by ezdeb", pkgName)
+		}
+		delete(state.Packages, pkgName)
+		return nil
+	})
+}
+
+func setHold(pkgNames []string, held bool) error {
+	// hold or unhold packages in one write, every package must be managed by ezdeb
+	return updateState(func(state *ezdebState) error {
+		for _, name := range pkgNames {
+			record, found := state.Packages[name]
+			if !found {
+				return fmt.Errorf("package %s is not managed by ezdeb", name)
+			}
+			if !held {
+				record.Hold = nil
+			} else if record.Hold == nil {
+				record.Hold = &holdState{Since: time.Now().UTC()}
+			}
+		}
+		return nil
+	})
+}
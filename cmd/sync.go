@@ -5,74 +5,359 @@ Copyright © 2023 Tony
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+const catalogURL = "https://gitlab.com/Charlie-117/ezdeb/-/raw/master/pkglist/pkglist.json"
+
+// catalogCache holds the validators of a synced catalog, kept next to it as <catalog>.cache
+type catalogCache struct {
+	URL          string `json:"url,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// catalogSync is the outcome of syncing one catalog
+type catalogSync struct {
+	// signedBy is the key that signed the catalog, empty if it is not signed
+	signedBy string
+	// unchanged is true if the remote catalog is the one already synced
+	unchanged bool
+	// first is true if the catalog was not synced before
+	first bool
+	diff  catalogDiff
+}
+
+func isRemoteLocation(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+func localCatalogPath(location string, name string) (string, error) {
+	// a local catalog is a path, a file:// url or a directory holding <name>.json or pkglist.json
+	path := strings.TrimPrefix(location, "file://")
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fileInfo.IsDir() {
+		return path, nil
+	}
+
+	for _, file := range []string{name + ".json", "pkglist.json"} {
+		if _, err := os.Stat(filepath.Join(path, file)); err == nil {
+			return filepath.Join(path, file), nil
+		}
+	}
+	return "", fmt.Errorf("no %s.json or pkglist.json in %s", name, path)
+}
+
+func readLocation(ctx context.Context, location string) ([]byte, error) {
+	// read a small file over http(s) or from a local path or file:// url
+	if isRemoteLocation(location) {
+		data, _, err := httpGet(ctx, location)
+		return data, err
+	}
+	return os.ReadFile(strings.TrimPrefix(location, "file://"))
+}
+
+func fetchCatalog(ctx context.Context, location string, cache catalogCache) ([]byte, catalogCache, bool, error) {
+	// fetch a catalog, over http(s) only if it changed since the cached validators
+	// return the data, the new validators and true if the server answered 304 Not Modified
+	if !isRemoteLocation(location) {
+		data, err := os.ReadFile(location)
+		return data, catalogCache{}, false, err
+	}
+
+	// validators are only valid for the mirror that sent them
+	if cache.URL != location {
+		cache = catalogCache{}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, cache, false, fmt.Errorf("failed to create request: %v", err)
+	}
+	if cache.ETag != "" {
+		req.Header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.LastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, cache, false, fmt.Errorf("failed to download %s: %v", location, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, cache, true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, cache, false, fmt.Errorf("failed to download %s: %s", location, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, cache, false, fmt.Errorf("failed to download %s: %v", location, err)
+	}
+
+	return data, catalogCache{URL: location, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, false, nil
+}
+
+func readCatalogCache(filePath string) catalogCache {
+	// a missing or broken cache only costs a full download
+	var cache catalogCache
+	if data, err := os.ReadFile(filePath + ".cache"); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	// write to a temporary file in the same directory and rename it over filePath
+	// so readers see either the old or the new file, never a truncated one
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func syncCatalog(ctx context.Context, c catalogConfig) (*catalogSync, error) {
+	// sync a catalog from its location, or from its mirrors in order if that fails
+	locations := append([]string{c.URL}, c.Mirrors...)
+
+	var errs []string
+	for _, location := range locations {
+		result, err := syncCatalogFrom(ctx, c, location)
+		if err == nil {
+			return result, nil
+		}
+		if len(locations) == 1 {
+			return nil, err
+		}
+		if verbose {
+			fmt.Println(Yellow, "Failed to sync catalog", c.Name, "from", location+":", err, Reset)
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", location, err))
+	}
+
+	return nil, fmt.Errorf("all mirrors failed: %s", strings.Join(errs, "; "))
+}
+
+func syncCatalogFrom(ctx context.Context, c catalogConfig, location string) (*catalogSync, error) {
+	// download a catalog and its signature and store it where loadCatalogEntries reads it
+	// the upstream list and catalogs added with a key must carry a valid signature
+	if !isRemoteLocation(location) {
+		path, err := localCatalogPath(location, c.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch catalog: %v", err)
+		}
+		location = path
+	}
+
+	filePath, err := catalogPath(c.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := os.ReadFile(filePath)
+	first := os.IsNotExist(err)
+
+	// only ask the server for changes if there is a catalog to keep
+	cache := catalogCache{}
+	if err == nil {
+		cache = readCatalogCache(filePath)
+	}
+
+	data, cache, notModified, err := fetchCatalog(ctx, location, cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch catalog: %v", err)
+	}
+	if notModified || (!first && bytes.Equal(data, current)) {
+		// mark the catalog as fresh for the age check
+		now := time.Now()
+		os.Chtimes(filePath, now, now)
+		return &catalogSync{unchanged: true}, nil
+	}
+
+	packages, err := validateCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog, keeping the current one: %v", err)
+	}
+
+	signed := c.Name == mainCatalog || c.Key != ""
+	result := &catalogSync{first: first}
+	var sig []byte
+	var sigErr error
+	if signed {
+		sig, sigErr = readLocation(ctx, location+".minisig")
+		if sigErr == nil {
+			result.signedBy, sigErr = verifyCatalog(c, data, sig)
+		}
+		if sigErr != nil {
+			if !allowUnsigned {
+				return nil, fmt.Errorf("failed to verify the catalog, keeping the current one: %v", sigErr)
+			}
+			fmt.Println(Yellow, "Using an unverified catalog", c.Name+":", sigErr, Reset)
+		}
+	}
+
+	// Create the directory if it does not exist
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	previous, _ := parseCatalog(current)
+	result.diff = diffCatalogs(previous, packages)
+
+	// keep the replaced version so sync --rollback can restore it
+	if !first {
+		currentSig, _ := os.ReadFile(filePath + ".minisig")
+		if err := saveSnapshot(c.Name, current, currentSig); err != nil {
+			return nil, fmt.Errorf("failed to save catalog history: %v", err)
+		}
+	}
+
+	if err := writeFileAtomic(filePath, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write file: %v", err)
+	}
+
+	// keep the signature next to the catalog so info can show the signer
+	if signed && sigErr == nil {
+		err = writeFileAtomic(filePath+".minisig", sig, 0644)
+	} else {
+		err = os.Remove(filePath + ".minisig")
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store signature: %v", err)
+	}
+
+	// the validators are only stored once the catalog they belong to is in place
+	if cacheData, err := json.Marshal(cache); err == nil {
+		writeFileAtomic(filePath+".cache", cacheData, 0644)
+	}
+
+	return result, nil
+}
+
+func printCatalogSync(name string, result *catalogSync) {
+	if result.unchanged {
+		fmt.Printf("Catalog %s is up to date\n", name)
+		return
+	}
+	if result.signedBy != "" {
+		fmt.Printf("Catalog %s signed by %s\n", name, result.signedBy)
+	}
+	fmt.Printf("Successfully synced catalog %s\n", name)
+	if result.first {
+		fmt.Println("", len(result.diff.added), "packages")
+		return
+	}
+	printCatalogDiff(result.diff)
+}
+
 // syncCmd represents the sync command
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Sync the packageList from the remote repository",
-	Long: `Sync the packageList from the remote repository
-Usage: ezdeb sync`,
+	Long: `Sync the packageList and every added catalog
+The packageList must be signed by the publisher key, otherwise the current one is kept.
+Catalogs added with --key must be signed by that key.
+Replaced catalogs are kept in ~/.ezdeb/catalog-history, --rollback restores the n-th one back.
+Usage: ezdeb sync [--rollback [n]] [--catalog name]`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		/*
-			Download the packageList from the remote repository
+			Download every catalog that changed since the last sync and its signature
+			validate it and verify the signature before replacing the current catalog
+			show which packages were added, removed or changed
 		*/
 
-		response, err := http.Get("https://gitlab.com/Charlie-117/ezdeb/-/raw/master/pkglist/pkglist.json")
-		if err != nil {
-			fmt.Printf("The HTTP request failed with error %s\n", err)
-			return
-		}
-
-		defer response.Body.Close()
-
-		// Create the output file
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			fmt.Printf("Failed to get home directory: %v\n", err)
-			return
-		}
-
-		dirPath := filepath.Join(homeDir, ".ezdeb")
-		filePath := filepath.Join(homeDir, ".ezdeb", "pkglist.json")
+		// restore an older catalog instead of syncing
+		if cmd.Flags().Changed("rollback") {
+			// --rollback takes its count as a separate argument too
+			if len(args) == 1 {
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					fmt.Println(Red, "Invalid rollback count", args[0], Reset)
+					return
+				}
+				rollback = n
+			}
 
-		// Create the directory if it does not exist
-		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-			err := os.MkdirAll(dirPath, os.ModePerm)
+			name := cmd.Flag("catalog").Value.String()
+			diff, id, err := rollbackCatalog(name, rollback)
 			if err != nil {
-				fmt.Printf("Failed to create directory: %v\n", err)
+				fmt.Println(Red, "Failed to roll back catalog", name+":", err, Reset)
 				return
 			}
+			fmt.Printf("Rolled back catalog %s to the version replaced at %s\n", name, id)
+			printCatalogDiff(diff)
+			return
 		}
 
+		ctx := context.Background()
 
-		file, err := os.Create(filePath)
+		catalogs, err := allCatalogs()
 		if err != nil {
-			fmt.Printf("Failed to create file: %v\n", err)
+			fmt.Println(Red, "Failed to read catalogs:", err, Reset)
 			return
 		}
-		defer file.Close()
 
-		// Copy the contents of the response body to the output file
-		_, err = io.Copy(file, response.Body)
-		if err != nil {
-			fmt.Printf("Failed to copy contents of response body to file: %v\n", err)
-			return
+		for _, c := range catalogs {
+			result, err := syncCatalog(ctx, c)
+			if err != nil {
+				fmt.Println(Red, "Failed to sync catalog", c.Name+":", err, Reset)
+				continue
+			}
+			printCatalogSync(c.Name, result)
 		}
 
-		fmt.Printf("Successfully synced the packageList from the remote repository\n")
-
 	},
 }
 
+// rollback is the number of catalog versions sync --rollback goes back
+var rollback int
+
 func init() {
 	rootCmd.AddCommand(syncCmd)
+	withLock(syncCmd)
+
+	syncCmd.Flags().IntVar(&rollback, "rollback", 0, "Restore the n-th previous version of the catalog instead of syncing")
+	syncCmd.Flags().Lookup("rollback").NoOptDefVal = "1"
+	syncCmd.Flags().String("catalog", mainCatalog, "Catalog to roll back")
+	syncCmd.Flags().BoolVar(&allowUnsigned, "allow-unsigned", false, "Accept a packageList without a valid signature")
 }
@@ -6,8 +6,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/spf13/cobra"
 )
@@ -24,22 +22,7 @@ func unholdPkg(pkgName string) error {
 		return fmt.Errorf("Package %s is not held", pkgName)
 	}
 
-	// delete the package config file
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Println(Red, "Failed to get user home directory", Reset)
-		return err
-	}
-
-	pkgPath := filepath.Join(homeDir, ".ezdeb", "held", pkgName+".json")
-
-	err = os.Remove(pkgPath)
-	if err != nil {
-		fmt.Println(Red, "Failed to remove held package", Reset)
-		return err
-	}
-
-	return nil
+	return setHold([]string{pkgName}, false)
 }
 
 // unholdCmd represents the unhold command
@@ -58,19 +41,22 @@ Usage: ezdeb unhold [flags] [package_name]`,
 
 		// if all flag is set then unhold all held packages
 		if cmd.Flag("all").Value.String() == "true" {
-			fmt.Println("Unholding all held packages\n")
-			homeDir, err := os.UserHomeDir()
+			fmt.Print("Unholding all held packages\n\n")
+			held, err := heldPackages()
 			if err != nil {
-				fmt.Println(Red, "Error: failed to get user home directory", Reset)
+				fmt.Println(Red, "Error: failed to read held packages:", err, Reset)
 				return
 			}
-			heldDirPath := filepath.Join(homeDir, ".ezdeb", "held")
-			// delete the heldDirPath
-			err = os.RemoveAll(heldDirPath)
+			// release every hold in one write
+			err = setHold(held, false)
 			if err != nil {
-				fmt.Println(Red, "Error: failed to remove held dir", Reset)
+				fmt.Println(Red, "Error: failed to unhold packages:", err, Reset)
 				return
 			}
+			for _, pkg := range held {
+				fmt.Println(Green, "Package", pkg, "unheld", Reset)
+				logger.Infof("unhold: %v", pkg)
+			}
 		}
 
 		if ((cmd.Flag("all").Value.String() == "false") && (len(args) < 1)) {
@@ -78,6 +64,7 @@ Usage: ezdeb unhold [flags] [package_name]`,
 			return
 		} else {
 			for _, pkg := range args {
+				pkg = dpkgName(pkg)
 				// if pkg is not installed skip
 				if !isInstalled(pkg) {
 					fmt.Println(Red, "Package", pkg, "not installed\n", Reset)
@@ -103,6 +90,7 @@ Usage: ezdeb unhold [flags] [package_name]`,
 
 func init() {
 	rootCmd.AddCommand(unholdCmd)
+	withLock(unholdCmd)
 
 	unholdCmd.Flags().BoolP("all", "a", false, "Unhold all held packages")
 }
@@ -9,10 +9,8 @@ import (
 	"os"
 	"os/exec"
 	"strings"
-	"path/filepath"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 func isInstalledU(packageName string) bool {
@@ -29,16 +27,10 @@ func isInstalledU(packageName string) bool {
 }
 
 func searchPkgDetailsU(pkgName string) bool {
-	// search for the package in the config file
+	// search for the package in the package lists
 	// return false if not found
-	packages := viper.Get("packages").([]interface{})
-	for _, pkg := range packages {
-		pkgMap := pkg.(map[string]interface{})
-		if pkgMap["name"].(string) == pkgName {
-				return true
-		}
-	}
-	return false
+	_, found := lookupPackage(pkgName)
+	return found
 }
 
 func uninstallPkg(pkgName string) error {
@@ -56,26 +48,9 @@ func uninstallPkg(pkgName string) error {
 }
 
 func deletePkgConfig(pkgName string) error {
-	// delete the package config file
+	// remove the package and its hold from the ezdeb state
 	// return error if failed
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-
-	dirPath := filepath.Join(homeDir, ".ezdeb", "packages")
-	filePath := filepath.Join(dirPath, pkgName+".json")
-
-	cmd := exec.Command("rm", filePath)
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	err = cmd.Run()
-	if err != nil {
-		return err
-	}
-	return nil
+	return removePackageState(pkgName)
 }
 
 // uninstallCmd represents the uninstall command
@@ -111,8 +86,9 @@ Usage: ezdeb uninstall <package_name>`,
 		for _, pkg := range args {
 
 			fmt.Printf("\n\nUninstalling package %v\n", pkg)
+			name := dpkgName(pkg)
 
-			if !(isInstalledU(pkg)) {
+			if !(isInstalledU(name)) {
 				fmt.Println(Red, "\n\nPackage ", pkg, " is not installed", Reset)
 				continue
 			}
@@ -122,19 +98,13 @@ Usage: ezdeb uninstall <package_name>`,
 				continue
 			}
 
-			if err := uninstallPkg(pkg); err != nil {
+			if err := uninstallPkg(name); err != nil {
 				fmt.Println(Red, "\n\nFailed to uninstall package ", pkg, Reset)
 				continue
 			} else {
-				if err := deletePkgConfig(pkg); err != nil {
+				if err := deletePkgConfig(name); err != nil {
 					fmt.Println(Yellow, "\n\nPackage ", pkg, " successfully uninstalled but config not removed", Reset)
 				} else {
-					if held, err := isHeldPkg(pkg); err == nil && held {
-						err = unholdPkg(pkg)
-						if err != nil {
-							fmt.Println(Red, "\n\nFailed to unhold package ", pkg, Reset)
-						}
-					}
 					fmt.Println(Green, "\n\nPackage ", pkg, " successfully uninstalled\n", Reset)
 					logger.Infof("uninstall: %v", pkg)
 				}
@@ -145,4 +115,5 @@ Usage: ezdeb uninstall <package_name>`,
 
 func init() {
 	rootCmd.AddCommand(uninstallCmd)
+	withLock(uninstallCmd)
 }
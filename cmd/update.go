@@ -7,44 +7,12 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"os"
 	"os/exec"
-	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"github.com/google/go-github/v50/github"
 )
 
-var pkgNames []string
-
-func listPkgConfigs(folderPath string) error {
-	// List all the package config files in the folder
-
-    files, err := ioutil.ReadDir(folderPath)
-    if err != nil {
-        return fmt.Errorf("failed to read directory: %v", err)
-    }
-
-    for _, file := range files {
-        if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-            fileName := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
-            pkgNames = append(pkgNames, fileName)
-        }
-    }
-
-	if len(pkgNames) == 0 {
-		return fmt.Errorf("No packages installed")
-	}
-
-    return nil
-}
-
 func checkIfInstalled(pkg string) bool {
 	// check if the package is installed in the system
 	isPkgInstalled, _ := exec.Command("dpkg", "-s", pkg).CombinedOutput()
@@ -56,159 +24,60 @@ func checkIfInstalled(pkg string) bool {
 	return false
 }
 
-func checkUpdateGh(pkg string, ghuser string, ghrepo string) (bool, error) {
-	// create a GH client and check if the package names or size don't match
-	// with the one in the config file of the pkg
-
-	client := github.NewClient(nil)
-	ctx := context.Background()
+// pendingUpdate is what checkUpdate found upstream for a package
+type pendingUpdate struct {
+	release Release
+	// version is the upstream version
+	version string
+	// fromTag is true if the version was read from the release tag
+	fromTag bool
+	// deb is the downloaded .deb, nil if the version came from the tag
+	deb *fetchedDeb
+}
 
-	// get latest release
-	ghRelease, _, err := client.Repositories.GetLatestRelease(ctx, ghuser, ghrepo)
+func checkUpdate(ctx context.Context, catalogPkg *Package, src Source) (*pendingUpdate, error) {
+	// get the upstream version of the latest release from the source index or its tag
+	// if the source has neither then download the .deb and read its control file
+	rel, err := src.Latest(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to get latest release: %v", err)
-	}
-
-	// find deb file asset
-	var asset *github.ReleaseAsset
-
-	// first search for .deb file with amd64 or x86_64 in name to avoid arm builds
-	for _, a := range ghRelease.Assets {
-		if filepath.Ext(a.GetName()) == ".deb" && (strings.Contains(a.GetName(), "amd64") || strings.Contains(a.GetName(), "x86_64")) {
-			asset = a
-			debName = a.GetName()
-			break
-		}
+		return nil, err
 	}
 
-	// if no .deb file was found with arch in name then search for .deb files
-	if asset == nil {
-		for _, a := range ghRelease.Assets {
-			if filepath.Ext(a.GetName()) == ".deb" {
-				asset = a
-				debName = a.GetName()
-				break
-			}
-		}
+	if rel.DebVersion != "" {
+		return &pendingUpdate{release: rel, version: rel.DebVersion}, nil
 	}
-
-	// if no .deb file was found then return error
-	if asset == nil {
-		return false, fmt.Errorf("no .deb file asset found in release")
+	if rel.Version != "" {
+		return &pendingUpdate{release: rel, version: rel.Version, fromTag: true}, nil
 	}
 
-	homeDir, err := os.UserHomeDir()
+	deb, err := fetchRelease(ctx, catalogPkg, src, rel)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	dirPath := filepath.Join(homeDir, ".ezdeb", "packages")
-
-	// Check if directory exists
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		if err != nil {
-			return false, err
-		}
-	}
+	return &pendingUpdate{release: rel, version: deb.info.Version, deb: deb}, nil
+}
 
-	// get info from the package.json file
-	pkgConfig := viper.New()
-	pkgConfig.SetConfigName(pkg)
-	pkgConfig.SetConfigType("json")
-	pkgConfig.AddConfigPath(dirPath)
-	err = pkgConfig.ReadInConfig()
+func compareWithInstalled(pkg string, upstream string, fromTag bool) (int, string, error) {
+	// compare the upstream version with the version installed by dpkg
+	// return -1, 0 or 1 if upstream is older, equal or newer and the installed version
+	// a release tag carries no epoch or debian revision so only compare the upstream part then
+	installed, err := installedVersion(pkg)
 	if err != nil {
-		return false, err
+		return 0, "", err
 	}
 
-	pkgSize := pkgConfig.GetInt("size")
-	pkgName := pkgConfig.GetString("version")
-
-	if (asset.GetSize() != pkgSize) || (pkgName != asset.GetName()) {
-		return true, nil
+	var cmp int
+	if fromTag {
+		cmp, err = compareUpstreamVersions(upstream, installed)
 	} else {
-		return false, nil
+		cmp, err = compareDebVersions(upstream, installed)
 	}
-}
-
-func checkUpdateUrl(pkg string, url string) (bool, error) {
-	// if the url is a dynamic url i.e it keeps changing the .deb name then
-	// we need to search for the package in the page and get the url of the .deb file
-	if !strings.Contains(url, ".deb") {
-		pageResp, err := http.Get(url)
-		if err != nil {
-			return false, fmt.Errorf("failed to get download link: %v", err)
-		}
-		defer pageResp.Body.Close()
-
-		// Read the response body into a buffer
-		body, err := ioutil.ReadAll(pageResp.Body)
-		if err != nil {
-			return false, fmt.Errorf("failed to get download link: %v", err)
-		}
-
-		// Find the URL of the .deb package
-		re := regexp.MustCompile(`"([^"]*\.deb)"`)
-		matches := re.FindSubmatch(body)
-		if len(matches) < 2 {
-			return false, fmt.Errorf("No .deb package found in response body")
-		}
-
-		// Construct the download URL of the .deb package
-		url := fmt.Sprintf("%s/%s", url, matches[1])
-		urlParts := strings.Split(url, "/")
-		debName = urlParts[len(urlParts)-1]
-	} else {
-		// if the url is a .deb file then we just need to grab the name of the .deb file
-		urlParts := strings.Split(url, "/")
-		debName = urlParts[len(urlParts)-1]
-	}
-
-	// get the size of the deb file from url
-	resp, err := http.Head(url)
-    if err != nil {
-        return false, err
-    }
-    defer resp.Body.Close()
-
-    size, err := strconv.Atoi(resp.Header.Get("Content-Length"))
-	if err != nil {
-		return false, err
-	}
-
-	// check if the size of the deb file is the same as the one in the package.json file
-	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return false, err
-	}
-
-	dirPath := filepath.Join(homeDir, ".ezdeb", "packages")
-
-	// Check if directory exists
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		if err != nil {
-			return false, err
-		}
-	}
-
-	// get info from the package.json file
-	pkgConfig := viper.New()
-	pkgConfig.SetConfigName(pkg)
-	pkgConfig.SetConfigType("json")
-	pkgConfig.AddConfigPath(dirPath)
-	err = pkgConfig.ReadInConfig()
-	if err != nil {
-		return false, err
-	}
-
-	pkgSize := pkgConfig.GetInt("size")
-
-	if (size != pkgSize) {
-		return true, nil
-	} else {
-		return false, nil
+		return 0, "", err
 	}
 
+	return cmp, installed, nil
 }
 
 func askBeforeUpdate(pkg string) bool {
@@ -240,9 +109,10 @@ Usage: ezdeb update [pkg]`,
 		ensuure it is installed
 		fetch the details
 		check the source
-		if gh then check deb name
-		if url then check size
-		if different then download
+		get the upstream version from the release tag or the .deb control file
+		compare it with the version installed by dpkg
+		if upstream is newer then download and install
+		if upstream is older then report the downgrade
 		if same then print no update
 
 		*/
@@ -254,17 +124,13 @@ Usage: ezdeb update [pkg]`,
 			return
 		}
 
-		homeDir, err := os.UserHomeDir()
+		pkgNames, err := managedPackages()
 		if err != nil {
-			fmt.Println(Red, "Failed to get user home directory", Reset)
+			fmt.Println(Red, "Failed to read installed packages:", err, Reset)
 			return
 		}
-
-		dirPath := filepath.Join(homeDir, ".ezdeb", "packages")
-
-		err = listPkgConfigs(dirPath)
-		if err != nil {
-			fmt.Println(Yellow, "Failed to list package configs", Reset)
+		if len(pkgNames) == 0 {
+			fmt.Println(Yellow, "No packages installed", Reset)
 			fmt.Println(Yellow, "Install a package first before updating...", Reset)
 			return
 		}
@@ -292,103 +158,97 @@ Usage: ezdeb update [pkg]`,
 			}
 		}
 
+		ctx := context.Background()
+
 		for _, pkg := range pkgNames {
-			if checkIfInstalled(pkg) {
-				fmt.Println(Cyan, "Checking update for", pkg, "...", Reset)
-				// fn from install.go
-				if searchPkgDetails(pkg) {
-					if ghuser != "" && ghrepo != "" {
-						if check, err := checkUpdateGh(pkg, ghuser, ghrepo); err == nil && check {
-							if cmd.Flag("check-only").Value.String() != "true" {
-								if held, err := isHeldPkg(pkg); err == nil && !held {
-									if askBeforeUpdate(pkg) {
-										if location, err := fetchGithubRelease(ghuser, ghrepo); err == nil {
-											if err = installPackage(location); err == nil {
-												if err = storePackageDetails(pkg, debName); err == nil {
-													logger.Infof("update: %v", pkg)
-													fmt.Println(Green, "Package", pkg, "updated successfully\n", Reset)
-													continue
-												} else {
-													fmt.Println(Yellow, "Package", pkg, "successfully updated but not logged\n", Reset)
-													continue
-												}
-											} else {
-												fmt.Println(Red, "Failed to update package", pkg, "\n", Reset)
-												continue
-											}
-										} else {
-											fmt.Println(Red, "Failed to fetch package", pkg, "\n", Reset)
-											continue
-										}
-									} else {
-										fmt.Println(Yellow, "Skipped updating package\n", Reset)
-										continue
-									}
-								} else {
-									fmt.Println(Yellow, "Skipped updating locked package", pkg, "\n", Reset)
-									continue
-								}
-							} else {
-								fmt.Println(Yellow, "Update available for package:", pkg, "\n", Reset)
-							}
-						} else {
-							fmt.Println(Green, "Package", pkg, "is up to date\n", Reset)
-							continue
-						}
-					} else if pkgurl != "" {
-						if check, err := checkUpdateUrl(pkg, pkgurl); err == nil && check {
-							if cmd.Flag("check-only").Value.String() != "true" {
-								if held, err := isHeldPkg(pkg); err == nil && !held {
-									if askBeforeUpdate(pkg) {
-										if location, err := fetchPackage(pkgurl); err == nil {
-											if err = installPackage(location); err == nil {
-												if err = storePackageDetails(pkg, debName); err == nil {
-													logger.Infof("update: %v", pkg)
-													fmt.Println(Green, "Package", pkg, "updated successfully\n", Reset)
-												} else {
-													fmt.Println(Yellow, "Package", pkg, "successfully updated but not logged\n", Reset)
-													continue
-												}
-											} else {
-												fmt.Println(Red, "Failed to update package", pkg, "\n", Reset)
-												continue
-											}
-										} else {
-											fmt.Println(Red, "Failed to fetch package", pkg, "\n", Reset)
-											continue
-										}
-									} else {
-										fmt.Println(Yellow, "Skipped updating package\n", Reset)
-										continue
-									}
-								} else {
-									fmt.Println(Yellow, "Skipped updating locked package", pkg, "\n", Reset)
-									continue
-								}
-							} else {
-								fmt.Println(Yellow, "Update available for package:", pkg, "\n", Reset)
-							}
-						} else {
-							fmt.Println(Green, "Package", pkg, "is up to date\n", Reset)
-							continue
-						}
-					} else {
-						fmt.Println(Red, "Failed to fetch details for Package", pkg, "\n", Reset)
-						continue
-					}
-				} else {
-					fmt.Println(Red, "Package", pkg, "details not found", "\n", Reset)
+			if !checkIfInstalled(pkg) {
+				continue
+			}
+
+			fmt.Println(Cyan, "Checking update for", pkg, "...", Reset)
+
+			// look the package up in the catalog entry it was installed from
+			catalog, qualified := "", pkg
+			if record, err := readPackageState(pkg); err == nil {
+				catalog, qualified = record.Catalog, record.qualifiedEntry()
+			}
+			catalogPkg, found := lookupPackage(qualified)
+			if !found {
+				fmt.Println(Red, "Package", pkg, "details not found", "\n", Reset)
+				continue
+			}
+
+			src, err := newSource(catalogPkg)
+			if err != nil {
+				fmt.Println(Red, "Failed to fetch details for Package", pkg, ":", err, "\n", Reset)
+				continue
+			}
+
+			upd, err := checkUpdate(ctx, catalogPkg, src)
+			if err != nil {
+				fmt.Println(Red, "Failed to check update for package", pkg, ":", err, "\n", Reset)
+				continue
+			}
+
+			cmp, installed, err := compareWithInstalled(pkg, upd.version, upd.fromTag)
+			if err != nil {
+				fmt.Println(Red, "Failed to compare versions for package", pkg, ":", err, "\n", Reset)
+				continue
+			}
+			if cmp < 0 {
+				fmt.Println(Yellow, "Package", pkg, "upstream version", upd.version, "is older than installed version", installed, "(downgrade), skipping\n", Reset)
+				continue
+			}
+			if cmp == 0 {
+				fmt.Println(Green, "Package", pkg, "is up to date\n", Reset)
+				continue
+			}
+
+			if cmd.Flag("check-only").Value.String() == "true" {
+				fmt.Println(Yellow, "Update available for package:", pkg, installed, "->", upd.version, "\n", Reset)
+				continue
+			}
+
+			if held, err := isHeldPkg(pkg); err != nil || held {
+				fmt.Println(Yellow, "Skipped updating locked package", pkg, "\n", Reset)
+				continue
+			}
+
+			if !askBeforeUpdate(pkg) {
+				fmt.Println(Yellow, "Skipped updating package\n", Reset)
+				continue
+			}
+
+			// the .deb was not downloaded yet if the version came from the tag
+			if upd.deb == nil {
+				if upd.deb, err = fetchRelease(ctx, catalogPkg, src, upd.release); err != nil {
+					fmt.Println(Red, "Failed to fetch package", pkg, ":", err, "\n", Reset)
 					continue
 				}
-			} else {
+				upd.version = upd.deb.info.Version
+			}
+
+			if err = installPackage(upd.deb.location); err != nil {
+				fmt.Println(Red, "Failed to update package", pkg, "\n", Reset)
 				continue
 			}
+
+			if err = storePackageDetails(catalog, catalogPkg, upd.release, upd.deb); err != nil {
+				fmt.Println(Yellow, "Package", pkg, "successfully updated but not logged\n", Reset)
+				continue
+			}
+
+			logger.Infof("update: %v", pkg)
+			fmt.Println(Green, "Package", pkg, "updated successfully\n", Reset)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(updateCmd)
+	withLock(updateCmd)
 
 	updateCmd.Flags().BoolP("check-only", "c", false, "Only check for updates")
+	updateCmd.Flags().BoolVar(&requireChecksum, "require-checksum", false, "Refuse packages without a known SHA256 checksum")
+	updateCmd.Flags().BoolVar(&allowUnsignedRepo, "allow-unsigned-repo", false, "Accept apt repositories without a gpg key in the catalog")
 }